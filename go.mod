@@ -1,4 +1,4 @@
-module go-ObuDiff
+module github.com/hizuheka/go-ObuDiff
 
 go 1.25.6
 
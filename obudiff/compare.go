@@ -0,0 +1,435 @@
+package obudiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// maxAlignRows は行の突き合わせで扱えるユニーク行数の上限です。
+// 各行を1つのruneに割り当てて diffmatchpatch で比較するため、
+// サロゲート領域を除いたUnicodeの範囲に収まる必要があります。
+const maxAlignRows = 0x10FFFF - 0x800
+
+// CompareReader は旧CSVと新CSVを行単位で突き合わせ、セルごとの差分の断片を返すリーダーです。
+// SegmentReader を実装しており、Read が返すセルは変更後の値です。
+// マーカーを介さずに差分を渡すため、セルに [- や {+ などの文字列が含まれていても差分と誤認しません
+type CompareReader struct {
+	rows     []alignedRow
+	pos      int
+	segments [][]diffmatchpatch.Diff
+}
+
+// alignedRow は突き合わせた旧行と新行の組です。行全体の追加・削除の場合は片方が nil です
+type alignedRow struct {
+	oldRecord, newRecord []string
+}
+
+// NewCompareReader は旧CSVと新CSVを全件読み込み、行の並びを揃えたリーダーを生成します
+func NewCompareReader(oldReader, newReader RecordReader, dmp *diffmatchpatch.DiffMatchPatch) (*CompareReader, error) {
+	oldRecords, err := readAllRecords(oldReader)
+	if err != nil {
+		return nil, fmt.Errorf("旧ファイルの読み取りに失敗: %w", err)
+	}
+	newRecords, err := readAllRecords(newReader)
+	if err != nil {
+		return nil, fmt.Errorf("新ファイルの読み取りに失敗: %w", err)
+	}
+
+	rows, err := alignRecords(oldRecords, newRecords, dmp)
+	if err != nil {
+		return nil, err
+	}
+	return &CompareReader{rows: rows}, nil
+}
+
+func (r *CompareReader) Read() ([]string, error) {
+	if r.pos >= len(r.rows) {
+		return nil, io.EOF
+	}
+	row := r.rows[r.pos]
+	r.rows[r.pos] = alignedRow{}
+	r.pos++
+	var cells []string
+	cells, r.segments = compareRow(row.oldRecord, row.newRecord)
+	return cells, nil
+}
+
+// Segments は直前に返したレコードの各セルの差分の断片を返します
+func (r *CompareReader) Segments() [][]diffmatchpatch.Diff {
+	return r.segments
+}
+
+// compareRow は旧レコードと新レコードの同じ位置のセル同士を比較し、各セルの変更後の値と差分の断片を返します。
+// 行全体の追加・削除の場合は oldRecord または newRecord に nil を渡します
+func compareRow(oldRecord, newRecord []string) (cells []string, segments [][]diffmatchpatch.Diff) {
+	n := max(len(oldRecord), len(newRecord))
+	cells = make([]string, n)
+	segments = make([][]diffmatchpatch.Diff, n)
+	for i := range n {
+		var oldCell string
+		if i < len(oldRecord) {
+			oldCell = oldRecord[i]
+		}
+		if i < len(newRecord) {
+			cells[i] = newRecord[i]
+		}
+		segments[i] = cellSegmentsOf(oldCell, cells[i])
+	}
+	return cells, segments
+}
+
+// readAllRecords はリーダーから全レコードを読み込みます
+func readAllRecords(reader RecordReader) ([][]string, error) {
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(records)+1, err)
+		}
+		records = append(records, cloneRecord(record))
+	}
+}
+
+// alignRecords は行をruneに置き換えて diffmatchpatch で比較し、
+// 一致・追加・削除・変更の各行を旧行と新行の組に変換します
+func alignRecords(oldRecords, newRecords [][]string, dmp *diffmatchpatch.DiffMatchPatch) ([]alignedRow, error) {
+	rowIndex := make(map[string]rune)
+	toRunes := func(records [][]string) ([]rune, error) {
+		runes := make([]rune, len(records))
+		for i, record := range records {
+			key := strings.Join(record, "\x00")
+			r, ok := rowIndex[key]
+			if !ok {
+				if len(rowIndex) >= maxAlignRows {
+					return nil, fmt.Errorf("ユニーク行数が上限 (%d) を超えたため行の突き合わせができません", maxAlignRows)
+				}
+				r = rune(len(rowIndex) + 1)
+				if r >= 0xD800 {
+					// サロゲート領域はstring変換で壊れるため避ける
+					r += 0x800
+				}
+				rowIndex[key] = r
+			}
+			runes[i] = r
+		}
+		return runes, nil
+	}
+
+	oldRunes, err := toRunes(oldRecords)
+	if err != nil {
+		return nil, err
+	}
+	newRunes, err := toRunes(newRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	// 行の突き合わせは最後まで行う必要があるのでタイムアウトを無効にする
+	timeout := dmp.DiffTimeout
+	dmp.DiffTimeout = 0
+	diffs := dmp.DiffMainRunes(oldRunes, newRunes, false)
+	dmp.DiffTimeout = timeout
+
+	rows := make([]alignedRow, 0, len(newRecords))
+	var oldPos, newPos int
+	var deleted [][]string
+
+	// 直前の削除行と今回の追加行を先頭から順に対応付けて変更行として扱う
+	flushDeleted := func() {
+		for _, record := range deleted {
+			rows = append(rows, alignedRow{oldRecord: record})
+		}
+		deleted = nil
+	}
+
+	for _, diff := range diffs {
+		n := len([]rune(diff.Text))
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			flushDeleted()
+			for _, record := range newRecords[newPos : newPos+n] {
+				rows = append(rows, alignedRow{oldRecord: record, newRecord: record})
+			}
+			oldPos += n
+			newPos += n
+		case diffmatchpatch.DiffDelete:
+			deleted = append(deleted, oldRecords[oldPos:oldPos+n]...)
+			oldPos += n
+		case diffmatchpatch.DiffInsert:
+			for _, record := range newRecords[newPos : newPos+n] {
+				if len(deleted) > 0 {
+					rows = append(rows, alignedRow{oldRecord: deleted[0], newRecord: record})
+					deleted = deleted[1:]
+				} else {
+					rows = append(rows, alignedRow{newRecord: record})
+				}
+			}
+			newPos += n
+		}
+	}
+	flushDeleted()
+	return rows, nil
+}
+
+// KeyedCompareReader はキー列の値で旧CSVと新CSVの行を突き合わせるリーダーです。
+// 両ファイルを外部ソートでキー順 (文字列としての辞書順。"10" は "2" より前) に並べ替えてマージ結合し、
+// キー順にレコードを返します。LineNumber は各レコードの元ファイル上の行番号を返します。
+// CompareReader と同様に SegmentReader を実装しており、Read が返すセルは変更後の値です
+type KeyedCompareReader struct {
+	oldReader *sortedRecordReader
+	newReader *sortedRecordReader
+	keyCols   []int
+
+	// 先読みしたレコードとその行番号 (nil かつ done の場合は入力の終端)
+	oldRecord, newRecord []string
+	oldLine, newLine     int
+	oldDone, newDone     bool
+
+	line     int                     // 直前に返したレコードの行番号
+	segments [][]diffmatchpatch.Diff // 直前に返したレコードの各セルの差分の断片
+}
+
+// NewKeyedCompareReader は旧CSVと新CSVをそれぞれ keyCols (0始まりの列番号) の値で
+// 外部ソートし、突き合わせるリーダーを返します。sortMemory は1つのランとして
+// メモリに保持するバイト数の目安で、tempDir はランを書き出すディレクトリです。
+// 使用後は Close で一時ファイルを削除してください
+func NewKeyedCompareReader(oldReader, newReader RecordReader, keyCols []int, sortMemory int, tempDir string) (*KeyedCompareReader, error) {
+	// 同じキーの行はファイル内の順序を保ったまま先頭から対応付ける
+	oldSorted, err := newSortedRecordReader(oldReader, keyCols, sortMemory, tempDir)
+	if err != nil {
+		return nil, fmt.Errorf("旧ファイルのソートに失敗: %w", err)
+	}
+	newSorted, err := newSortedRecordReader(newReader, keyCols, sortMemory, tempDir)
+	if err != nil {
+		oldSorted.Close()
+		return nil, fmt.Errorf("新ファイルのソートに失敗: %w", err)
+	}
+	return &KeyedCompareReader{oldReader: oldSorted, newReader: newSorted, keyCols: keyCols}, nil
+}
+
+func (r *KeyedCompareReader) Read() ([]string, error) {
+	if err := r.fill(); err != nil {
+		return nil, err
+	}
+
+	cmp := 0
+	switch {
+	case r.oldDone && r.newDone:
+		return nil, io.EOF
+	case r.oldDone:
+		cmp = 1
+	case r.newDone:
+		cmp = -1
+	default:
+		cmp = compareKeys(r.oldRecord, r.newRecord, r.keyCols)
+	}
+
+	var cells []string
+	switch {
+	case cmp < 0:
+		cells, r.segments = compareRow(r.oldRecord, nil)
+		r.line = r.oldLine
+		r.oldRecord = nil
+	case cmp > 0:
+		cells, r.segments = compareRow(nil, r.newRecord)
+		r.line = r.newLine
+		r.newRecord = nil
+	default:
+		cells, r.segments = compareRow(r.oldRecord, r.newRecord)
+		r.line = r.newLine
+		r.oldRecord = nil
+		r.newRecord = nil
+	}
+	return cells, nil
+}
+
+// Segments は直前に返したレコードの各セルの差分の断片を返します
+func (r *KeyedCompareReader) Segments() [][]diffmatchpatch.Diff {
+	return r.segments
+}
+
+// LineNumber は直前に返したレコードの元ファイル上の行番号を返します。
+// 削除された行は旧ファイル、それ以外の行は新ファイルの行番号です
+func (r *KeyedCompareReader) LineNumber() int {
+	return r.line
+}
+
+// fill は旧/新それぞれの先読みレコードが空であれば次のレコードを読み込みます
+func (r *KeyedCompareReader) fill() error {
+	if r.oldRecord == nil && !r.oldDone {
+		record, err := r.oldReader.Read()
+		if err == io.EOF {
+			r.oldDone = true
+		} else if err != nil {
+			return fmt.Errorf("旧ファイルの読み取りに失敗: %w", err)
+		}
+		r.oldRecord = record
+		r.oldLine = r.oldReader.LineNumber()
+	}
+	if r.newRecord == nil && !r.newDone {
+		record, err := r.newReader.Read()
+		if err == io.EOF {
+			r.newDone = true
+		} else if err != nil {
+			return fmt.Errorf("新ファイルの読み取りに失敗: %w", err)
+		}
+		r.newRecord = record
+		r.newLine = r.newReader.LineNumber()
+	}
+	return nil
+}
+
+// Close はソート用の一時ファイルを削除します
+func (r *KeyedCompareReader) Close() error {
+	return errors.Join(r.oldReader.Close(), r.newReader.Close())
+}
+
+// countingReader は読み込んだレコードを数え、その連番を行番号として返すリーダーです
+type countingReader struct {
+	reader RecordReader
+	line   int
+}
+
+func (r *countingReader) Read() ([]string, error) {
+	record, err := r.reader.Read()
+	if err == nil {
+		r.line++
+	}
+	return record, err
+}
+
+func (r *countingReader) LineNumber() int {
+	return r.line
+}
+
+// compareKeys は2つのレコードをキー列の値で比較します。存在しない列は空文字として扱います
+func compareKeys(a, b []string, keyCols []int) int {
+	for _, col := range keyCols {
+		var av, bv string
+		if col < len(a) {
+			av = a[col]
+		}
+		if col < len(b) {
+			bv = b[col]
+		}
+		if c := strings.Compare(av, bv); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// parseKeyColumns は -key の指定 (1始まりの列番号またはヘッダー名をカンマ区切り) を
+// 0始まりの列番号のリストに変換します
+func parseKeyColumns(spec string, headers []string) ([]int, error) {
+	var keyCols []int
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("キー列の番号は1以上で指定してください: %d", n)
+			}
+			keyCols = append(keyCols, n-1)
+			continue
+		}
+		idx := slices.Index(headers, name)
+		if idx < 0 {
+			return nil, fmt.Errorf("キー列 %q がヘッダーに見つかりません", name)
+		}
+		keyCols = append(keyCols, idx)
+	}
+	if len(keyCols) == 0 {
+		return nil, fmt.Errorf("キー列が指定されていません")
+	}
+	return keyCols, nil
+}
+
+// Compare は oldR と newR の2つのCSVを比較し、差分を Format の形式で w に出力します。
+// KeySpec を指定した場合はキー列で行を突き合わせ、指定しない場合は行の並びから対応を求めます。
+// FileHeader が有効な場合は各入力の先頭行を読み飛ばし、Headers が nil なら新しい側の先頭行をヘッダーとして使用します
+func Compare(ctx context.Context, cfg Config, oldR, newR io.Reader, w io.Writer) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.InputFormat != "" && cfg.InputFormat != "csv" {
+		return errors.New("InputFormat (-input-format) は Compare (-old/-new) では指定できません")
+	}
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	reader, closeReader, err := newCompareRecordReader(ctx, &cfg, oldR, newR, dmp)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+	return executeProcessing(ctx, cfg, reader, w, dmp)
+}
+
+// newCompareRecordReader は2つの入力をデコードし、KeySpec の指定に応じた比較リーダーを返します。
+// FileHeader が有効で Headers が nil の場合は新しい側の先頭行を cfg.Headers に設定します。
+// 入力の読み込みは ctx が取り消されると打ち切ります。戻り値の関数で外部ソートの一時ファイルを削除します
+func newCompareRecordReader(ctx context.Context, cfg *Config, oldR, newR io.Reader, dmp *diffmatchpatch.DiffMatchPatch) (RecordReader, func(), error) {
+	logger := cfg.logger()
+	oldInput, err := decodeInput(oldR, *cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("旧ファイルの読み込みに失敗: %w", err)
+	}
+	newInput, err := decodeInput(newR, *cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("新ファイルの読み込みに失敗: %w", err)
+	}
+
+	// ヘッダー行も数え、キー列で並べ替えた後もファイル上の行番号を出力できるようにする
+	oldReader := &countingReader{reader: contextReader{ctx, newRecordReader(oldInput, *cfg, false)}}
+	newReader := &countingReader{reader: contextReader{ctx, newRecordReader(newInput, *cfg, false)}}
+
+	if cfg.FileHeader {
+		if _, err := oldReader.Read(); err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("旧ファイルのヘッダー行の読み取りに失敗: %w", err)
+		}
+		newHeader, err := newReader.Read()
+		if err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("新ファイルのヘッダー行の読み取りに失敗: %w", err)
+		}
+		if cfg.Headers == nil {
+			cfg.Headers = newHeader
+		}
+	}
+
+	if cfg.KeySpec == "" {
+		reader, err := NewCompareReader(oldReader, newReader, dmp)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, func() {}, nil
+	}
+
+	keyCols, err := parseKeyColumns(cfg.KeySpec, cfg.Headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Info("キー列で行を突き合わせます", "key", cfg.KeySpec)
+	keyedReader, err := NewKeyedCompareReader(oldReader, newReader, keyCols, cfg.SortMemoryMB<<20, cfg.TempDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeReader := func() {
+		if err := keyedReader.Close(); err != nil {
+			logger.Warn("一時ファイルの削除に失敗しました", "error", err)
+		}
+	}
+	return keyedReader, closeReader, nil
+}
@@ -0,0 +1,292 @@
+package obudiff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func newTestCompareReader(t *testing.T, oldInput, newInput string) *CompareReader {
+	t.Helper()
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	reader, err := NewCompareReader(newReader(oldInput, false), newReader(newInput, false), dmp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return reader
+}
+
+func TestCompareReader(t *testing.T) {
+	t.Run("Align", func(t *testing.T) {
+		oldInput := "1,Apple,OK\n2,Banana,OK\n3,Orange,NG"
+		newInput := "1,Apple,OK\n2,Banana,NG\n4,Grape,\n3,Orange,NG"
+		reader := newTestCompareReader(t, oldInput, newInput)
+
+		got := readAllSegments(t, reader)
+		expected := []string{
+			"1,Apple,OK",
+			"2,Banana,[-OK-]{+NG+}",
+			"{+4+},{+Grape+},",
+			"3,Orange,NG",
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("RowDelete", func(t *testing.T) {
+		reader := newTestCompareReader(t, "1,A\n2,B\n3,C", "1,A\n3,C")
+		got := readAllSegments(t, reader)
+		expected := []string{"1,A", "[-2-],[-B-]", "3,C"}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("ColumnCountMismatch", func(t *testing.T) {
+		reader := newTestCompareReader(t, "1,A", "1,A,X")
+		if got := readAllSegments(t, reader); len(got) != 1 || got[0] != "1,A,{+X+}" {
+			t.Errorf("unexpected records: %q", got)
+		}
+	})
+
+	t.Run("NewValues", func(t *testing.T) {
+		// Read が返すセルは変更後の値 (削除された行は空文字)
+		reader := newTestCompareReader(t, "1,A\n2,B", "1,X")
+		got := readAllJoined(t, reader)
+		if expected := []string{"1,X", ","}; strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+}
+
+func TestCompareReaderProcessing(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	t.Run("HTMLTable_RowStyle", func(t *testing.T) {
+		reader := newTestCompareReader(t, "1,A\n2,B", "1,A\n3,C")
+		var out strings.Builder
+		cfg := Config{Format: "html"}
+		if err := executeProcessing(context.Background(), cfg, reader, &out, dmp); err != nil {
+			t.Fatal(err)
+		}
+		// 変更行は1行として対応付けられる
+		if !strings.Contains(out.String(), `<td><del class="diff-del">2</del><ins class="diff-add">3</ins></td>`) {
+			t.Errorf("Missing changed cell:\n%s", out.String())
+		}
+	})
+
+	t.Run("CSVList", func(t *testing.T) {
+		reader := newTestCompareReader(t, "1,Apple,OK\n2,Banana,OK", "1,Apple,OK\n2,Banana,NG\n3,Orange,OK")
+		var out strings.Builder
+		cfg := Config{LightMode: true}
+		if err := executeProcessing(context.Background(), cfg, reader, &out, dmp); err != nil {
+			t.Fatal(err)
+		}
+		expected := `Line,Column,DiffValue
+2,3,[-OK-]{+NG+}
+3,1,{+3+}
+3,2,{+Orange+}
+3,3,{+OK+}
+`
+		if out.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+		}
+	})
+}
+
+func readAllJoined(t *testing.T, reader RecordReader) []string {
+	t.Helper()
+	var got []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, strings.Join(record, ","))
+	}
+}
+
+// readAllSegments は SegmentReader の各レコードを、セルの差分の断片をマーカー付きテキストにしてカンマで連結した文字列で返します
+func readAllSegments(t *testing.T, reader SegmentReader) []string {
+	t.Helper()
+	var got []string
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var cells []string
+		for _, segments := range reader.Segments() {
+			cells = append(cells, FormatDiffsToText(segments, nil))
+		}
+		got = append(got, strings.Join(cells, ","))
+	}
+}
+
+func TestCompareMarkerSyntaxInCells(t *testing.T) {
+	// セルに含まれる [- や +} などの文字列を差分マーカーと誤認しない
+	oldCSV := "1,AB[-1-]X,a,{+p\n2,q-],r\n"
+	newCSV := "1,AB[-1-]X,x+}y,{+p\n2,q-],r\n"
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"Align", Config{Format: "jsonl"}},
+		{"Key", Config{Format: "jsonl", KeySpec: "1", TempDir: t.TempDir()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := Compare(context.Background(), tt.cfg, strings.NewReader(oldCSV), strings.NewReader(newCSV), &out); err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"line":1,"column":3,"old":"a","new":"x+}y","segments":[{"op":"delete","text":"a"},{"op":"insert","text":"x+}y"}]}` + "\n"
+			if out.String() != expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+			}
+
+			out.Reset()
+			if err := Compare(context.Background(), tt.cfg, strings.NewReader(oldCSV), strings.NewReader(oldCSV), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != "" {
+				t.Errorf("identical files should have no diff, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Run("FileHeaderAndKey", func(t *testing.T) {
+		oldCSV := "ID,Name,Status\n2,Banana,OK\n1,Apple,OK\n"
+		newCSV := "ID,Name,Status\n1,Apple,NG\n2,Banana,OK\n"
+		var out strings.Builder
+		cfg := Config{LightMode: true, DetailList: true, FileHeader: true, KeySpec: "ID", TempDir: t.TempDir()}
+		if err := Compare(context.Background(), cfg, strings.NewReader(oldCSV), strings.NewReader(newCSV), &out); err != nil {
+			t.Fatal(err)
+		}
+		// 行番号はヘッダー行を含めた新ファイル上の行番号
+		expected := `Line,Column,Header,ChangeType,OldValue,NewValue,Diff
+2,3,Status,change,OK,NG,[-OK-]{+NG+}
+`
+		if out.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tempDir := t.TempDir()
+		cfg := Config{KeySpec: "1", SortMemoryMB: 1, TempDir: tempDir}
+		err := Compare(ctx, cfg, strings.NewReader("1,A\n2,B"), strings.NewReader("1,A\n2,C"), io.Discard)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+			t.Errorf("temporary files should be removed: %v", entries)
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		cfg := Config{FileHeader: true, KeySpec: "Code"}
+		err := Compare(context.Background(), cfg, strings.NewReader("ID\n1"), strings.NewReader("ID\n1"), io.Discard)
+		if err == nil {
+			t.Error("expected error for unknown key column")
+		}
+	})
+}
+
+func TestKeyedCompareReader(t *testing.T) {
+	t.Run("JoinByKey", func(t *testing.T) {
+		oldInput := "3,Orange,100\n1,Apple,200\n2,Banana,300"
+		newInput := "1,Apple,250\n4,Grape,400\n3,Orange,100"
+		reader, err := NewKeyedCompareReader(newReader(oldInput, false), newReader(newInput, false), []int{0}, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAllSegments(t, reader)
+		expected := []string{
+			"1,Apple,[-200-]{+250+}",
+			"[-2-],[-Banana-],[-300-]",
+			"3,Orange,100",
+			"{+4+},{+Grape+},{+400+}",
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+	})
+
+	t.Run("CompositeKeyAndDuplicates", func(t *testing.T) {
+		oldInput := "A,1,x\nA,1,y\nB,2,z"
+		newInput := "B,2,z\nA,1,x"
+		reader, err := NewKeyedCompareReader(newReader(oldInput, false), newReader(newInput, false), []int{0, 1}, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAllSegments(t, reader)
+		// 重複キーはファイル内の順に対応付けられ、余った行は削除行になる
+		expected := []string{
+			"A,1,x",
+			"[-A-],[-1-],[-y-]",
+			"B,2,z",
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+	})
+}
+
+func TestKeyedCompareLineNumber(t *testing.T) {
+	// キー順 ("10" が "2" より前) に並べ替えても、元ファイル上の行番号を出力する
+	cfg := Config{KeySpec: "1", LightMode: true, TempDir: t.TempDir()}
+	var out strings.Builder
+	err := Compare(context.Background(), cfg, strings.NewReader("2,b\n10,a\n3,c"), strings.NewReader("2,b\n10,A\n4,d"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Line,Column,DiffValue\n2,2,[-a-]{+A+}\n3,1,[-3-]\n3,2,[-c-]\n3,1,{+4+}\n3,2,{+d+}\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestParseKeyColumns(t *testing.T) {
+	headers := []string{"ID", "Name", "Code"}
+
+	t.Run("IndexAndName", func(t *testing.T) {
+		keyCols, err := parseKeyColumns("1, Code", headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keyCols) != 2 || keyCols[0] != 0 || keyCols[1] != 2 {
+			t.Errorf("unexpected key columns: %v", keyCols)
+		}
+	})
+
+	t.Run("UnknownName", func(t *testing.T) {
+		if _, err := parseKeyColumns("Price", headers); err == nil {
+			t.Error("expected error for unknown header name")
+		}
+	})
+
+	t.Run("InvalidIndex", func(t *testing.T) {
+		if _, err := parseKeyColumns("0", headers); err == nil {
+			t.Error("expected error for column 0")
+		}
+	})
+}
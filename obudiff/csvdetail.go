@@ -0,0 +1,61 @@
+package obudiff
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// detailListHeader は -detail 指定時の軽量CSVの見出し行です
+var detailListHeader = []string{"Line", "Column", "Header", "ChangeType", "OldValue", "NewValue", "Diff"}
+
+// changeType は差分のあるセルの種類を返します。
+// 変更前が空なら add、変更後が空なら delete、それ以外は change です
+func changeType(oldText, newText string) string {
+	switch {
+	case oldText == "":
+		return "add"
+	case newText == "":
+		return "delete"
+	default:
+		return "change"
+	}
+}
+
+// csvDetailListRenderer は差分のあるセルごとに、変更前と変更後の値を別の列に分けて出力します。
+// Excel で変更前・変更後の値による絞り込みや並べ替えができるようにするための形式です
+type csvDetailListRenderer struct {
+	w       recordWriter
+	markers *MarkerSyntax
+}
+
+func (r *csvDetailListRenderer) Begin(headers []string) error {
+	if err := r.w.Write(detailListHeader); err != nil {
+		return fmt.Errorf("軽量CSVヘッダーの書き込みに失敗: %w", err)
+	}
+	return nil
+}
+
+func (r *csvDetailListRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if !cell.IsDiff {
+			continue
+		}
+		oldText, newText := diffTexts(cell.Diffs)
+		record := []string{
+			strconv.Itoa(row.Line),
+			strconv.Itoa(cell.Column + 1),
+			cell.Header,
+			changeType(oldText, newText),
+			oldText,
+			newText,
+			FormatDiffsToText(cell.Diffs, r.markers),
+		}
+		if err := r.w.Write(record); err != nil {
+			return fmt.Errorf("軽量CSV行の書き込みに失敗 (line %d): %w", row.Line, err)
+		}
+	}
+	return nil
+}
+
+func (r *csvDetailListRenderer) End() error { return nil }
@@ -0,0 +1,49 @@
+package obudiff
+
+import "testing"
+
+func TestChangeType(t *testing.T) {
+	tests := []struct{ old, new, expected string }{
+		{"", "NG", "add"},
+		{"OK", "", "delete"},
+		{"OK", "NG", "change"},
+	}
+	for _, tt := range tests {
+		if got := changeType(tt.old, tt.new); got != tt.expected {
+			t.Errorf("changeType(%q, %q) = %q, want %q", tt.old, tt.new, got, tt.expected)
+		}
+	}
+}
+
+func TestCSVDetailList(t *testing.T) {
+	t.Run("WithHeaders", func(t *testing.T) {
+		cfg := Config{LightMode: true, DetailList: true, Headers: testHeaders}
+		out, err := runTest(t, cfg, testInputDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `Line,Column,Header,ChangeType,OldValue,NewValue,Diff
+1,3,Status,change,OK,NG,[-OK-]{+NG+}
+1,4,Memo,change,Note 1,Note 2,Note [-1-]{+2+}
+3,3,Status,change,NG,OK,[-NG-]{+OK+}
+`
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+
+	t.Run("AddDelete", func(t *testing.T) {
+		cfg := Config{LightMode: true, DetailList: true}
+		out, err := runTest(t, cfg, "1,{+new+},[-old-]")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `Line,Column,Header,ChangeType,OldValue,NewValue,Diff
+1,2,,add,,new,{+new+}
+1,3,,delete,old,,[-old-]
+`
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+}
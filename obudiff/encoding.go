@@ -0,0 +1,190 @@
+package obudiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodingAliases は htmlindex/ianaindex では引けない、よく使われる文字コードの別名です
+var encodingAliases = map[string]encoding.Encoding{
+	"sjis":    japanese.ShiftJIS,
+	"cp932":   japanese.ShiftJIS,
+	"ms932":   japanese.ShiftJIS,
+	"eucjp":   japanese.EUCJP,
+	"jis":     japanese.ISO2022JP,
+	"utf-16":  unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf16":   unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf16le": unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf16be": unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+}
+
+// LookupEncoding は Config.Encoding や Config.OutputEncoding に指定する名前から文字コードを返します。
+// WHATWG (htmlindex) と IANA (ianaindex) の名前のほか、sjis や cp932 などの別名も受け付けます。
+// UTF-8 の場合は変換が不要なため nil を返します
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	enc, ok := encodingAliases[key]
+	if !ok {
+		var err error
+		if enc, err = htmlindex.Get(key); err != nil {
+			enc, err = ianaindex.IANA.Encoding(key)
+			if err != nil || enc == nil {
+				return nil, fmt.Errorf("未対応の文字コードです: %q", name)
+			}
+		}
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// AutoEncoding は Config.Encoding で文字コードを自動判定する指定です
+const AutoEncoding = "auto"
+
+// detectSampleSize は文字コードの自動判定で先読みするバイト数です
+const detectSampleSize = 64 * 1024
+
+// iso2022JPEscapes は ISO-2022-JP の文字集合を切り替えるエスケープシーケンスです
+var iso2022JPEscapes = [][]byte{[]byte("\x1b$B"), []byte("\x1b$@"), []byte("\x1b(J"), []byte("\x1b(I")}
+
+// detectEncoding は先頭の sample から入力の文字コードを推定し、LookupEncoding で引ける名前を返します。
+// BOM、ISO-2022-JP のエスケープシーケンス、UTF-8 としての妥当性の順に調べ、
+// いずれでもない場合は Shift_JIS と EUC-JP のうちバイト列の並びとして矛盾の少ない方を選びます。
+// confident は判定に使った規則に反するバイト列がなかったかどうかです。
+// complete は sample が入力全体かどうかで、入力の途中までが ASCII のみの場合は
+// 以降に Shift_JIS などの文字が現れうるため utf-8 を確定せずに返します
+func detectEncoding(sample []byte, complete bool) (name string, confident bool) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", true
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return "utf-16le", true
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return "utf-16be", true
+	}
+	for _, esc := range iso2022JPEscapes {
+		if bytes.Contains(sample, esc) {
+			return "iso-2022-jp", true
+		}
+	}
+	if isASCII(sample) {
+		return "utf-8", complete
+	}
+	if validUTF8Prefix(sample) {
+		return "utf-8", true
+	}
+
+	sjisInvalid, sjisKana := scanShiftJIS(sample)
+	eucInvalid, eucKana := scanEUCJP(sample)
+	switch {
+	case eucInvalid < sjisInvalid, eucInvalid == sjisInvalid && eucKana > sjisKana:
+		return "euc-jp", eucInvalid == 0
+	default:
+		return "shift_jis", sjisInvalid == 0
+	}
+}
+
+// isASCII は sample が ASCII の文字のみからなるかを返します
+func isASCII(sample []byte) bool {
+	for _, c := range sample {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// validUTF8Prefix は sample が UTF-8 として妥当かを返します。
+// 先読みの境界で途切れた末尾の1文字は判定から除きます
+func validUTF8Prefix(sample []byte) bool {
+	for i := 0; i < utf8.UTFMax && i < len(sample); i++ {
+		if utf8.RuneStart(sample[len(sample)-1-i]) {
+			if !utf8.FullRune(sample[len(sample)-1-i:]) {
+				sample = sample[:len(sample)-1-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(sample)
+}
+
+// scanShiftJIS は sample を Shift_JIS として走査し、不正なバイト列の数とかな文字の数を返します
+func scanShiftJIS(sample []byte) (invalid, kana int) {
+	for i := 0; i < len(sample); i++ {
+		c := sample[i]
+		switch {
+		case c < 0x80, c >= 0xA1 && c <= 0xDF:
+			// ASCII と半角カナ
+		case (c >= 0x81 && c <= 0x9F) || (c >= 0xE0 && c <= 0xFC):
+			if i+1 >= len(sample) {
+				return invalid, kana // 先読みの境界で途切れた2バイト文字
+			}
+			t := sample[i+1]
+			if t < 0x40 || t == 0x7F || t > 0xFC {
+				invalid++
+				continue
+			}
+			if (c == 0x82 && t >= 0x9F && t <= 0xF1) || (c == 0x83 && t >= 0x40 && t <= 0x96) {
+				kana++
+			}
+			i++
+		default:
+			invalid++
+		}
+	}
+	return invalid, kana
+}
+
+// scanEUCJP は sample を EUC-JP として走査し、不正なバイト列の数とかな文字の数を返します
+func scanEUCJP(sample []byte) (invalid, kana int) {
+	isEUC := func(b byte) bool { return b >= 0xA1 && b <= 0xFE }
+	for i := 0; i < len(sample); i++ {
+		c := sample[i]
+		switch {
+		case c < 0x80:
+		case c == 0x8E: // 半角カナ
+			if i+1 >= len(sample) {
+				return invalid, kana
+			}
+			if t := sample[i+1]; t < 0xA1 || t > 0xDF {
+				invalid++
+				continue
+			}
+			i++
+		case c == 0x8F: // 補助漢字 (3バイト)
+			if i+2 >= len(sample) {
+				return invalid, kana
+			}
+			if !isEUC(sample[i+1]) || !isEUC(sample[i+2]) {
+				invalid++
+				continue
+			}
+			i += 2
+		case isEUC(c):
+			if i+1 >= len(sample) {
+				return invalid, kana
+			}
+			t := sample[i+1]
+			if !isEUC(t) {
+				invalid++
+				continue
+			}
+			if c == 0xA4 || c == 0xA5 {
+				kana++
+			}
+			i++
+		default:
+			invalid++
+		}
+	}
+	return invalid, kana
+}
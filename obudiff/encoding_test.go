@@ -0,0 +1,180 @@
+package obudiff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	for _, name := range []string{"sjis", "CP932", "Shift_JIS", "euc-jp", "ISO-2022-JP", "utf-16le", "UTF-16", "gb18030", "windows-1252"} {
+		enc, err := LookupEncoding(name)
+		if err != nil || enc == nil {
+			t.Errorf("%q: expected encoding, got %v (err=%v)", name, enc, err)
+		}
+	}
+	if enc, err := LookupEncoding("UTF-8"); err != nil || enc != nil {
+		t.Errorf("UTF-8 should need no transform, got %v (err=%v)", enc, err)
+	}
+	if _, err := LookupEncoding("no-such-encoding"); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}
+
+func TestDecodeInputEncoding(t *testing.T) {
+	text := "1,りんご,[-赤-]{+青+}\n"
+
+	tests := []struct {
+		name     string
+		encoding string
+		encode   func(string) ([]byte, error)
+	}{
+		{"EUC-JP", "euc-jp", func(s string) ([]byte, error) { return japanese.EUCJP.NewEncoder().Bytes([]byte(s)) }},
+		{"ISO-2022-JP", "iso-2022-jp", func(s string) ([]byte, error) { return japanese.ISO2022JP.NewEncoder().Bytes([]byte(s)) }},
+		{"UTF-16LE with BOM", "utf-16le", func(s string) ([]byte, error) {
+			return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(s))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.encode(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := decodeInput(bytes.NewReader(data), Config{Encoding: tt.encoding})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != text {
+				t.Errorf("expected %q, got %q", text, got)
+			}
+		})
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	cfg := Config{OutputEncoding: "sjis"}
+
+	var outBuf bytes.Buffer
+	writer := bufio.NewWriter(&outBuf)
+	if err := executeProcessing(context.Background(), cfg, newReader("1,りんご,[-赤-]{+青+}", false), writer, dmp); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(outBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1,りんご,[-赤-]{+青+}\n"; string(decoded) != expected {
+		t.Errorf("expected %q, got %q", expected, decoded)
+	}
+
+	// Shift_JIS で表せない文字はエラーになる
+	err = executeProcessing(context.Background(), cfg, newReader("1,😀", false), io.Discard, dmp)
+	if err == nil {
+		t.Errorf("expected encoding error, got %v", err)
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	text := "1,りんご,[-赤-]{+青+}\n2,ミカン,黄色\n"
+	encode := func(t *testing.T, e encoding.Encoding) []byte {
+		t.Helper()
+		b, err := e.NewEncoder().Bytes([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		sample   func(t *testing.T) []byte
+		expected string
+	}{
+		{"UTF-8", func(t *testing.T) []byte { return []byte(text) }, "utf-8"},
+		{"UTF-8 BOM", func(t *testing.T) []byte { return append([]byte{0xEF, 0xBB, 0xBF}, text...) }, "utf-8"},
+		{"UTF-8 truncated", func(t *testing.T) []byte { return []byte(text)[:4] }, "utf-8"},
+		{"UTF-16LE BOM", func(t *testing.T) []byte {
+			return encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM))
+		}, "utf-16le"},
+		{"Shift_JIS", func(t *testing.T) []byte { return encode(t, japanese.ShiftJIS) }, "shift_jis"},
+		{"EUC-JP", func(t *testing.T) []byte { return encode(t, japanese.EUCJP) }, "euc-jp"},
+		{"ISO-2022-JP", func(t *testing.T) []byte { return encode(t, japanese.ISO2022JP) }, "iso-2022-jp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, confident := detectEncoding(tt.sample(t), false)
+			if name != tt.expected || !confident {
+				t.Errorf("expected %s, got %s (confident=%v)", tt.expected, name, confident)
+			}
+		})
+	}
+
+	t.Run("ASCII", func(t *testing.T) {
+		// ASCII のみの先読みは、入力全体でなければ以降の文字コードが分からないため確定しない
+		sample := []byte("1,apple,[-red-]{+blue+}\n")
+		if name, confident := detectEncoding(sample, true); name != "utf-8" || !confident {
+			t.Errorf("complete input: expected utf-8 (confident), got %s (confident=%v)", name, confident)
+		}
+		if name, confident := detectEncoding(sample, false); name != "utf-8" || confident {
+			t.Errorf("partial input: expected utf-8 (not confident), got %s (confident=%v)", name, confident)
+		}
+	})
+}
+
+func TestDecodeInputAutoEncodingASCIIPrefix(t *testing.T) {
+	// 先読みの範囲が ASCII のみで、以降に Shift_JIS の文字がある入力は警告する
+	text := strings.Repeat("1,abc\n", detectSampleSize/6+1) + "2,りんご\n"
+	data, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	if _, err := decodeInput(bytes.NewReader(data), Config{Encoding: AutoEncoding, Logger: logger}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logBuf.String(), "level=WARN") {
+		t.Errorf("undetermined encoding should be warned:\n%s", logBuf.String())
+	}
+}
+
+func TestDecodeInputAutoEncoding(t *testing.T) {
+	text := "1,りんご,[-赤-]{+青+}\n"
+	data, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	r, err := decodeInput(bytes.NewReader(data), Config{Encoding: AutoEncoding, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != text {
+		t.Errorf("expected %q, got %q", text, got)
+	}
+	if !bytes.Contains(logBuf.Bytes(), []byte("encoding=shift_jis")) {
+		t.Errorf("detected encoding should be logged:\n%s", logBuf.String())
+	}
+}
@@ -0,0 +1,62 @@
+package obudiff
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// recordWriter はCSVのレコードを1行ずつ書き込む先です (*csv.Writer など)
+type recordWriter interface {
+	Write(record []string) error
+}
+
+// newCSVWriter は cfg に応じた csv.Writer と、レコードの書き込み先を返します。
+// ExcelMode の場合は改行をCRLFにし、UTF-8で出力するときは先頭にBOMを書き込みます。
+// また、数式として解釈されるセルを無害化する formulaGuardWriter を書き込み先として返します
+func newCSVWriter(w io.Writer, cfg Config) (*csv.Writer, recordWriter, error) {
+	csvWriter := csv.NewWriter(w)
+	if !cfg.ExcelMode {
+		return csvWriter, csvWriter, nil
+	}
+
+	csvWriter.UseCRLF = true
+	// OutputEncoding でUTF-8以外を指定した場合 (Shift_JIS など) はBOMを付けない
+	if enc, _ := LookupEncoding(cfg.OutputEncoding); enc == nil {
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return nil, nil, fmt.Errorf("BOMの書き込みに失敗: %w", err)
+		}
+	}
+	return csvWriter, formulaGuardWriter{csvWriter}, nil
+}
+
+// formulaGuardWriter は Excel で数式として解釈されるセルの先頭に ' を付けてから書き込みます (CSVインジェクション対策)
+type formulaGuardWriter struct {
+	w recordWriter
+}
+
+func (g formulaGuardWriter) Write(record []string) error {
+	guarded := make([]string, len(record))
+	for i, cell := range record {
+		guarded[i] = guardFormula(cell)
+	}
+	return g.w.Write(guarded)
+}
+
+// plainNumberRegex は -1, +81, -1.5e3 のような10進数の数値です。
+// strconv.ParseFloat と異なり、-inf や +NaN, 16進数の表記は含みません
+var plainNumberRegex = regexp.MustCompile(`^[+-]?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+// guardFormula はセルが =, +, -, @, タブ, CR で始まる場合に先頭へ ' を付けます。
+// -1 や +81 のように10進数の数値である値はそのまま返します
+func guardFormula(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+	if plainNumberRegex.MatchString(cell) {
+		return cell
+	}
+	return "'" + cell
+}
@@ -0,0 +1,86 @@
+package obudiff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestGuardFormula(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(1)", "'@SUM(1)"},
+		{"\tx", "'\tx"},
+		{"-1", "-1"},
+		{"+81", "+81"},
+		{"1.5e3", "1.5e3"},
+		{"-1.5E+3", "-1.5E+3"},
+		{"-inf", "'-inf"},
+		{"+Inf", "'+Inf"},
+		{"-0x1p3", "'-0x1p3"},
+		{"+NaN", "'+NaN"},
+		{"-1_000", "'-1_000"},
+		{"[-OK-]{+NG+}", "[-OK-]{+NG+}"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := guardFormula(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestExcelCSVOutput(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	input := "1,=1+1,[-OK-]{+NG+}\n2,-5,りんご"
+
+	run := func(t *testing.T, cfg Config) []byte {
+		t.Helper()
+		var outBuf bytes.Buffer
+		writer := bufio.NewWriter(&outBuf)
+		if err := executeProcessing(context.Background(), cfg, newReader(input, false), writer, dmp); err != nil {
+			t.Fatal(err)
+		}
+		writer.Flush()
+		return outBuf.Bytes()
+	}
+
+	t.Run("UTF8", func(t *testing.T) {
+		got := run(t, Config{ExcelMode: true})
+		expected := "\uFEFF1,'=1+1,[-OK-]{+NG+}\r\n2,-5,りんご\r\n"
+		if string(got) != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("LightMode", func(t *testing.T) {
+		got := run(t, Config{ExcelMode: true, LightMode: true})
+		expected := "\uFEFFLine,Column,DiffValue\r\n1,3,[-OK-]{+NG+}\r\n"
+		if string(got) != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("ShiftJIS", func(t *testing.T) {
+		got := run(t, Config{ExcelMode: true, OutputEncoding: "sjis"})
+		decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Shift_JIS の場合はBOMを付けない
+		expected := "1,'=1+1,[-OK-]{+NG+}\r\n2,-5,りんご\r\n"
+		if string(decoded) != expected {
+			t.Errorf("expected %q, got %q", expected, decoded)
+		}
+	})
+}
@@ -0,0 +1,387 @@
+package obudiff
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// DefaultSortMemory は外部ソートで1つのランとしてメモリに保持するレコードの目安サイズ(バイト)です
+const DefaultSortMemory = 256 << 20
+
+// sortMergeFanIn は外部ソートで一度にマージするランの数です。
+// ランがこれより多い場合は複数回に分けてマージし、同時に開く一時ファイルの数を入力の大きさによらず一定に保ちます
+var sortMergeFanIn = 64
+
+// sortedRecordReader はキー列の値でレコードを並べ替えて返すリーダーです。
+// 入力が memoryLimit を超える場合はソート済みのランを一時ファイルへ書き出し、
+// 読み込み時にそれらをマージすることでメモリ使用量を一定に保ちます。
+// ランが sortMergeFanIn を超える場合は、あらかじめ sortMergeFanIn 個ずつマージしてランの数を減らします。
+// 各レコードの並べ替え前の行番号を保持し、LineNumber で返します
+type sortedRecordReader struct {
+	keyCols []int
+
+	// 一時ファイルへ書き出さずに済んだ場合のメモリ上のレコード
+	records []numberedRecord
+	pos     int
+	line    int // 直前に返したレコードの行番号
+
+	runs []*sortRun
+	heap sortRunHeap
+}
+
+// sortRun は一時ファイルに書き出したソート済みランの1つです。
+// 一時ファイルはマージで読み込む間だけ開きます
+type sortRun struct {
+	index   int
+	name    string
+	file    *os.File // 読み込み中でない場合は nil
+	r       *bufio.Reader
+	current numberedRecord
+}
+
+// newSortedRecordReader は reader のレコードを全件読み込み、keyCols の値で安定ソートしたリーダーを返します。
+// 行番号は reader が LineNumberReader を実装していればその値を、そうでなければ読み込んだレコードの連番を使用します。
+// tempDir が空文字の場合は os.TempDir() を使用します。使用後は Close で一時ファイルを削除してください
+func newSortedRecordReader(reader RecordReader, keyCols []int, memoryLimit int, tempDir string) (*sortedRecordReader, error) {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultSortMemory
+	}
+	sr := &sortedRecordReader{keyCols: keyCols}
+
+	var records []numberedRecord
+	var size int
+	for lineCount := 1; ; lineCount++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sr.Close()
+			return nil, fmt.Errorf("line %d: %w", lineCount, err)
+		}
+		line := lineCount
+		if lr, ok := reader.(LineNumberReader); ok {
+			line = lr.LineNumber()
+		}
+		records = append(records, numberedRecord{line: line, cells: cloneRecord(record)})
+		size += recordSize(record)
+
+		if size >= memoryLimit {
+			if err := sr.spill(records, tempDir); err != nil {
+				sr.Close()
+				return nil, err
+			}
+			records = nil
+			size = 0
+		}
+	}
+
+	if len(sr.runs) == 0 {
+		slices.SortStableFunc(records, sr.compare)
+		sr.records = records
+		return sr, nil
+	}
+
+	if len(records) > 0 {
+		if err := sr.spill(records, tempDir); err != nil {
+			sr.Close()
+			return nil, err
+		}
+	}
+	for len(sr.runs) > sortMergeFanIn {
+		if err := sr.mergePass(tempDir); err != nil {
+			sr.Close()
+			return nil, err
+		}
+	}
+	h, err := sr.openRuns(sr.runs)
+	if err != nil {
+		sr.Close()
+		return nil, err
+	}
+	sr.heap = h
+	return sr, nil
+}
+
+// openRuns はランの一時ファイルを開き、先頭レコードを読み込んだヒープを返します。
+// 空のランはヒープに含めません
+func (r *sortedRecordReader) openRuns(runs []*sortRun) (sortRunHeap, error) {
+	h := sortRunHeap{compare: r.compare}
+	for _, run := range runs {
+		f, err := os.Open(run.name)
+		if err != nil {
+			return h, fmt.Errorf("一時ファイルを開けません: %w", err)
+		}
+		run.file = f
+		run.r = bufio.NewReader(f)
+		if err := run.next(); err != nil {
+			if err == io.EOF {
+				continue
+			}
+			return h, err
+		}
+		h.runs = append(h.runs, run)
+	}
+	heap.Init(&h)
+	return h, nil
+}
+
+// mergePass はランを先頭から sortMergeFanIn 個ずつマージし、ランの数を減らします。
+// マージ済みのランの一時ファイルは削除します。隣り合うランをラン番号順にまとめるため、ソートの安定性は保たれます
+func (r *sortedRecordReader) mergePass(tempDir string) error {
+	var merged []*sortRun
+	for len(r.runs) > 0 {
+		group := r.runs[:min(sortMergeFanIn, len(r.runs))]
+		run := group[0]
+		if len(group) > 1 {
+			var err error
+			if run, err = r.mergeRuns(group, tempDir); err != nil {
+				r.runs = append(merged, r.runs...)
+				return err
+			}
+		}
+		run.index = len(merged)
+		merged = append(merged, run)
+		r.runs = r.runs[len(group):]
+	}
+	r.runs = merged
+	return nil
+}
+
+// mergeRuns は group のランをマージして1つのランとして一時ファイルへ書き出し、group の一時ファイルを削除します
+func (r *sortedRecordReader) mergeRuns(group []*sortRun, tempDir string) (run *sortRun, err error) {
+	h, err := r.openRuns(group)
+	defer func() {
+		for _, g := range group {
+			if g.file != nil {
+				g.file.Close()
+				g.file = nil
+			}
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(tempDir, "obudiff-sort-*.run")
+	if err != nil {
+		return nil, fmt.Errorf("一時ファイルの作成に失敗: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	var buf []byte
+	for h.Len() > 0 {
+		g := h.runs[0]
+		buf = appendRunRecord(buf[:0], g.current)
+		if _, err := w.Write(buf); err != nil {
+			return nil, fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+		}
+		if err := g.next(); err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+	}
+
+	for _, g := range group {
+		g.file.Close()
+		g.file = nil
+		os.Remove(g.name)
+	}
+	return &sortRun{name: f.Name()}, nil
+}
+
+func (r *sortedRecordReader) compare(a, b numberedRecord) int {
+	return compareKeys(a.cells, b.cells, r.keyCols)
+}
+
+// spill はレコードをソートして一時ファイルへ1つのランとして書き出します
+func (r *sortedRecordReader) spill(records []numberedRecord, tempDir string) error {
+	slices.SortStableFunc(records, r.compare)
+
+	f, err := os.CreateTemp(tempDir, "obudiff-sort-*.run")
+	if err != nil {
+		return fmt.Errorf("一時ファイルの作成に失敗: %w", err)
+	}
+	defer f.Close()
+	r.runs = append(r.runs, &sortRun{index: len(r.runs), name: f.Name()})
+
+	w := bufio.NewWriter(f)
+	var buf []byte
+	for _, record := range records {
+		buf = appendRunRecord(buf[:0], record)
+		if _, err := w.Write(buf); err != nil {
+			return fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("一時ファイルへの書き込みに失敗: %w", err)
+	}
+	return nil
+}
+
+func (r *sortedRecordReader) Read() ([]string, error) {
+	if len(r.runs) == 0 {
+		if r.pos >= len(r.records) {
+			return nil, io.EOF
+		}
+		record := r.records[r.pos]
+		r.records[r.pos] = numberedRecord{}
+		r.pos++
+		r.line = record.line
+		return record.cells, nil
+	}
+
+	if r.heap.Len() == 0 {
+		return nil, io.EOF
+	}
+	run := r.heap.runs[0]
+	record := run.current
+	if err := run.next(); err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+		heap.Pop(&r.heap)
+	} else {
+		heap.Fix(&r.heap, 0)
+	}
+	r.line = record.line
+	return record.cells, nil
+}
+
+// LineNumber は直前に返したレコードの並べ替え前の行番号を返します
+func (r *sortedRecordReader) LineNumber() int {
+	return r.line
+}
+
+// Close は一時ファイルを閉じて削除します
+func (r *sortedRecordReader) Close() error {
+	var errs []error
+	for _, run := range r.runs {
+		if run.file != nil {
+			if err := run.file.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := os.Remove(run.name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.runs = nil
+	r.heap.runs = nil
+	return errors.Join(errs...)
+}
+
+// next はランから次のレコードを読み込み current に設定します
+func (run *sortRun) next() error {
+	record, err := readRunRecord(run.r)
+	if err != nil {
+		run.current = numberedRecord{}
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("一時ファイルの読み取りに失敗 (%s): %w", run.name, err)
+	}
+	run.current = record
+	return nil
+}
+
+// sortRunHeap は各ランの先頭レコードが最小のものを取り出すヒープです。
+// キーが等しい場合はラン番号の小さい方を優先し、ソートの安定性を保ちます
+type sortRunHeap struct {
+	runs    []*sortRun
+	compare func(a, b numberedRecord) int
+}
+
+func (h *sortRunHeap) Len() int { return len(h.runs) }
+func (h *sortRunHeap) Less(i, j int) bool {
+	if c := h.compare(h.runs[i].current, h.runs[j].current); c != 0 {
+		return c < 0
+	}
+	return h.runs[i].index < h.runs[j].index
+}
+func (h *sortRunHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *sortRunHeap) Push(x any)    { h.runs = append(h.runs, x.(*sortRun)) }
+func (h *sortRunHeap) Pop() any {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+// recordSize はレコードがメモリ上で占めるおおよそのバイト数を返します
+func recordSize(record []string) int {
+	size := 24
+	for _, field := range record {
+		size += 16 + len(field)
+	}
+	return size
+}
+
+// appendRunRecord はレコードを行番号・フィールド数・各フィールドの長さを前置した形式で buf に追加します
+func appendRunRecord(buf []byte, record numberedRecord) []byte {
+	buf = binary.AppendUvarint(buf, uint64(record.line))
+	buf = binary.AppendUvarint(buf, uint64(len(record.cells)))
+	for _, field := range record.cells {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+// readRunRecord は appendRunRecord で書き出したレコードを1件読み込みます
+func readRunRecord(r *bufio.Reader) (numberedRecord, error) {
+	line, err := binary.ReadUvarint(r)
+	if err != nil {
+		return numberedRecord{}, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return numberedRecord{}, noEOF(err)
+	}
+	record := make([]string, n)
+	var buf []byte
+	for i := range record {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return numberedRecord{}, noEOF(err)
+		}
+		buf = slices.Grow(buf[:0], int(size))[:size]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return numberedRecord{}, noEOF(err)
+		}
+		record[i] = string(buf)
+	}
+	return numberedRecord{line: int(line), cells: record}, nil
+}
+
+// noEOF はレコードの途中で発生した io.EOF を io.ErrUnexpectedEOF に変換します
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
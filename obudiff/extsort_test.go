@@ -0,0 +1,181 @@
+package obudiff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSortedRecordReader(t *testing.T) {
+	input := "3,c\n1,a\n2,b\n1,a2\n3,c2\n2,b2"
+	expected := []string{"1,a", "1,a2", "2,b", "2,b2", "3,c", "3,c2"}
+
+	t.Run("InMemory", func(t *testing.T) {
+		reader, err := newSortedRecordReader(newReader(input, false), []int{0}, 0, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		if len(reader.runs) != 0 {
+			t.Errorf("expected no spilled runs, got %d", len(reader.runs))
+		}
+		got := readAllJoined(t, reader)
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("Spill", func(t *testing.T) {
+		tempDir := t.TempDir()
+		// 1レコードごとにランを書き出すようにメモリ上限を小さくする
+		reader, err := newSortedRecordReader(newReader(input, false), []int{0}, 1, tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reader.runs) != 6 {
+			t.Errorf("expected 6 spilled runs, got %d", len(reader.runs))
+		}
+		got := readAllJoined(t, reader)
+		// 同じキーのレコードは入力順を保つ
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("temporary files should be removed, found %d", len(entries))
+		}
+	})
+
+	t.Run("MultiPassMerge", func(t *testing.T) {
+		defer func(n int) { sortMergeFanIn = n }(sortMergeFanIn)
+		sortMergeFanIn = 2
+
+		tempDir := t.TempDir()
+		reader, err := newSortedRecordReader(newReader(input, false), []int{0}, 1, tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 6つのランを 6 → 3 → 2 と減らしてから読み込む
+		if len(reader.runs) != 2 {
+			t.Errorf("expected 2 runs after merging, got %d", len(reader.runs))
+		}
+		if entries, _ := os.ReadDir(tempDir); len(entries) != 2 {
+			t.Errorf("merged runs should be removed, found %d files", len(entries))
+		}
+		var got []string
+		var lines []int
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, strings.Join(record, ","))
+			lines = append(lines, reader.LineNumber())
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		if expected := []int{2, 4, 3, 6, 1, 5}; !slices.Equal(lines, expected) {
+			t.Errorf("expected lines %v, got %v", expected, lines)
+		}
+
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+			t.Errorf("temporary files should be removed, found %d", len(entries))
+		}
+	})
+
+	t.Run("LineNumber", func(t *testing.T) {
+		// 並べ替えた後も各レコードの入力上の行番号を返す (ランを書き出した場合も同じ)
+		for _, memoryLimit := range []int{0, 1} {
+			reader, err := newSortedRecordReader(newReader(input, false), []int{0}, memoryLimit, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lines []int
+			for {
+				if _, err := reader.Read(); err != nil {
+					break
+				}
+				lines = append(lines, reader.LineNumber())
+			}
+			reader.Close()
+			if expected := []int{2, 4, 3, 6, 1, 5}; !slices.Equal(lines, expected) {
+				t.Errorf("memoryLimit=%d: expected lines %v, got %v", memoryLimit, expected, lines)
+			}
+		}
+	})
+
+	t.Run("RunEncoding", func(t *testing.T) {
+		// 改行・カンマ・空フィールドを含むレコードもそのまま復元される
+		records := [][]string{{"a\nb", "", "c,d"}, {}, {""}}
+		var buf []byte
+		for i, record := range records {
+			buf = appendRunRecord(buf, numberedRecord{line: i + 1, cells: record})
+		}
+		r := bufio.NewReader(bytes.NewReader(buf))
+		for i, want := range records {
+			got, err := readRunRecord(r)
+			if err != nil {
+				t.Fatalf("record[%d]: unexpected error: %v", i, err)
+			}
+			if !slices.Equal(got.cells, want) || got.line != i+1 {
+				t.Errorf("record[%d]: expected %d:%q, got %d:%q", i, i+1, want, got.line, got.cells)
+			}
+		}
+		if _, err := readRunRecord(r); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+		if _, err := readRunRecord(bufio.NewReader(bytes.NewReader(buf[:3]))); err != io.ErrUnexpectedEOF {
+			t.Errorf("expected io.ErrUnexpectedEOF for truncated data, got %v", err)
+		}
+	})
+}
+
+func TestKeyedCompareReaderSpill(t *testing.T) {
+	var oldInput, newInput strings.Builder
+	for i := 100; i > 0; i-- {
+		fmt.Fprintf(&oldInput, "%03d,old\n", i)
+	}
+	for i := 1; i <= 100; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&newInput, "%03d,new\n", i)
+		} else {
+			fmt.Fprintf(&newInput, "%03d,old\n", i)
+		}
+	}
+
+	reader, err := NewKeyedCompareReader(newReader(oldInput.String(), false), newReader(newInput.String(), false), []int{0}, 64, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	got := readAllSegments(t, reader)
+	if len(got) != 100 {
+		t.Fatalf("expected 100 records, got %d", len(got))
+	}
+	if got[0] != "001,old" {
+		t.Errorf("unexpected first record: %q", got[0])
+	}
+	if got[9] != "010,[-old-]{+new+}" {
+		t.Errorf("unexpected changed record: %q", got[9])
+	}
+}
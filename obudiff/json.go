@@ -0,0 +1,97 @@
+package obudiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// jsonSegment は差分の断片1つ分です。Op は equal, insert, delete のいずれかです
+type jsonSegment struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// jsonDiffCell は差分のあるセル1つ分の出力です
+type jsonDiffCell struct {
+	Line     int           `json:"line"`
+	Column   int           `json:"column"` // 1始まりの列番号
+	Header   string        `json:"header,omitempty"`
+	Old      string        `json:"old"`
+	New      string        `json:"new"`
+	Segments []jsonSegment `json:"segments"`
+}
+
+// jsonOps は diffmatchpatch の操作と JSON 出力での名前の対応です
+var jsonOps = map[diffmatchpatch.Operation]string{
+	diffmatchpatch.DiffEqual:  "equal",
+	diffmatchpatch.DiffInsert: "insert",
+	diffmatchpatch.DiffDelete: "delete",
+}
+
+// newJSONDiffCell は1セル分の差分から出力用の値を作成します
+func newJSONDiffCell(line int, c *DiffCell) jsonDiffCell {
+	cell := jsonDiffCell{Line: line, Column: c.Column + 1, Header: c.Header, Segments: make([]jsonSegment, len(c.Diffs))}
+	cell.Old, cell.New = diffTexts(c.Diffs)
+	for i, d := range c.Diffs {
+		cell.Segments[i] = jsonSegment{Op: jsonOps[d.Type], Text: d.Text}
+	}
+	return cell
+}
+
+// jsonRenderer は差分のあるセルを1セル1オブジェクトとして出力します。
+// lines が true の場合は JSON Lines (1行1オブジェクト)、false の場合は JSON の配列として出力します
+type jsonRenderer struct {
+	w     *errWriter
+	lines bool
+	buf   bytes.Buffer
+	enc   *json.Encoder
+	count int
+}
+
+func (r *jsonRenderer) Begin(headers []string) error {
+	r.enc = json.NewEncoder(&r.buf)
+	r.enc.SetEscapeHTML(false)
+	return nil
+}
+
+func (r *jsonRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if !cell.IsDiff {
+			continue
+		}
+		r.buf.Reset()
+		if err := r.enc.Encode(newJSONDiffCell(row.Line, cell)); err != nil {
+			return fmt.Errorf("JSONの生成に失敗 (line %d): %w", row.Line, err)
+		}
+		switch {
+		case r.lines:
+		case r.count == 0:
+			io.WriteString(r.w, "[\n")
+		default:
+			io.WriteString(r.w, ",\n")
+		}
+		if r.lines {
+			r.w.Write(r.buf.Bytes())
+		} else {
+			r.w.Write(bytes.TrimSuffix(r.buf.Bytes(), []byte("\n")))
+		}
+		r.count++
+	}
+	return r.w.err
+}
+
+func (r *jsonRenderer) End() error {
+	if !r.lines {
+		if r.count == 0 {
+			io.WriteString(r.w, "[]\n")
+		} else {
+			io.WriteString(r.w, "\n]\n")
+		}
+	}
+	return r.w.err
+}
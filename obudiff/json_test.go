@@ -0,0 +1,78 @@
+package obudiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	expected := []jsonDiffCell{
+		{Line: 1, Column: 3, Header: "Status", Old: "OK", New: "NG", Segments: []jsonSegment{{"delete", "OK"}, {"insert", "NG"}}},
+		{Line: 1, Column: 4, Header: "Memo", Old: "Note 1", New: "Note 2", Segments: []jsonSegment{{"equal", "Note "}, {"delete", "1"}, {"insert", "2"}}},
+		{Line: 3, Column: 3, Header: "Status", Old: "NG", New: "OK", Segments: []jsonSegment{{"delete", "NG"}, {"insert", "OK"}}},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "json", Headers: testHeaders}, testInputDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []jsonDiffCell
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected:\n%+v\nGot:\n%+v", expected, got)
+		}
+	})
+
+	t.Run("JSONLines", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "jsonl", Headers: testHeaders}, testInputDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if len(lines) != len(expected) {
+			t.Fatalf("expected %d lines, got %d:\n%s", len(expected), len(lines), out)
+		}
+		for i, line := range lines {
+			var got jsonDiffCell
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("line %d: invalid JSON: %v", i+1, err)
+			}
+			if !reflect.DeepEqual(got, expected[i]) {
+				t.Errorf("line %d: expected %+v, got %+v", i+1, expected[i], got)
+			}
+		}
+	})
+
+	t.Run("NoDiff", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "json"}, testInputNoDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "[]\n" {
+			t.Errorf("expected empty array, got %q", out)
+		}
+		out, err = runTest(t, Config{Format: "jsonl"}, testInputNoDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "" {
+			t.Errorf("expected no output, got %q", out)
+		}
+	})
+
+	t.Run("NoHeaderNoEscape", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "jsonl"}, "1,[-<a>-]{+&b+}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"line":1,"column":2,"old":"<a>","new":"&b","segments":[{"op":"delete","text":"<a>"},{"op":"insert","text":"&b"}]}` + "\n"
+		if out != expected {
+			t.Errorf("expected %s, got %s", expected, out)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package obudiff
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// markdownEscaper はセル内の文字列が表の区切りや書式として解釈されないようにエスケープします
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"`", "\\`",
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// FormatDiffsToMarkdown は差分を GitHub Flavored Markdown に変換します。
+// 削除は ~~old~~、追加は **new** で表し、前後の空白は強調の外に出します。
+// 空白のみの断片や、句読点・記号で始まる (終わる) 断片が文字と隣り合う場合は、
+// GFM の規則で強調として解釈されないため <del>/<ins> で囲みます
+func FormatDiffsToMarkdown(diffs []diffmatchpatch.Diff) string {
+	var builder strings.Builder
+	for i, diff := range diffs {
+		if diff.Type == diffmatchpatch.DiffEqual {
+			builder.WriteString(markdownEscaper.Replace(diff.Text))
+			continue
+		}
+
+		mark, tag := "~~", "del"
+		if diff.Type == diffmatchpatch.DiffInsert {
+			mark, tag = "**", "ins"
+		}
+		body := strings.TrimSpace(diff.Text)
+		if body == "" {
+			fmt.Fprintf(&builder, "<%s>%s</%s>", tag, markdownEscaper.Replace(diff.Text), tag)
+			continue
+		}
+		start := strings.Index(diff.Text, body)
+		builder.WriteString(markdownEscaper.Replace(diff.Text[:start]))
+
+		escaped := markdownEscaper.Replace(body)
+		suffix := markdownEscaper.Replace(diff.Text[start+len(body):])
+		// 直後の文字は空白の後ろか次の一致部分の先頭 (次が削除・追加なら区切り文字かタグになる)
+		after := suffix
+		if after == "" && i+1 < len(diffs) && diffs[i+1].Type == diffmatchpatch.DiffEqual {
+			after = markdownEscaper.Replace(diffs[i+1].Text)
+		}
+		if canEmphasize(builder.String(), escaped, after) {
+			builder.WriteString(mark + escaped + mark)
+		} else {
+			fmt.Fprintf(&builder, "<%s>%s</%s>", tag, escaped, tag)
+		}
+		builder.WriteString(suffix)
+	}
+	return builder.String()
+}
+
+// canEmphasize は before と after の間にある body を ~~ や ** で囲んだときに、
+// GFM の区切り文字の規則 (left-flanking / right-flanking) で強調として解釈されるかどうかを返します
+func canEmphasize(before, body, after string) bool {
+	first, _ := utf8.DecodeRuneInString(body)
+	last, _ := utf8.DecodeLastRuneInString(body)
+	prev, prevSize := utf8.DecodeLastRuneInString(before)
+	next, nextSize := utf8.DecodeRuneInString(after)
+	if isMarkdownPunct(first) && prevSize > 0 && isMarkdownWord(prev) {
+		return false
+	}
+	if isMarkdownPunct(last) && nextSize > 0 && isMarkdownWord(next) {
+		return false
+	}
+	return true
+}
+
+// isMarkdownPunct は CommonMark で句読点として扱われる文字 (Unicode の句読点と記号) かどうかを返します
+func isMarkdownPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// isMarkdownWord は空白でも句読点でもない文字かどうかを返します
+func isMarkdownWord(r rune) bool {
+	return !unicode.IsSpace(r) && !isMarkdownPunct(r)
+}
+
+// writeMarkdownRow は1行分のセルを表の行として書き込みます (セルはエスケープ済みであること)
+func writeMarkdownRow(w io.Writer, cells []string) {
+	io.WriteString(w, "|")
+	for _, c := range cells {
+		fmt.Fprintf(w, " %s |", c)
+	}
+	io.WriteString(w, "\n")
+}
+
+// writeMarkdownTableHeader は表の見出し行と区切り行を書き込みます
+func writeMarkdownTableHeader(w io.Writer, titles []string) {
+	escaped := make([]string, len(titles))
+	separator := make([]string, len(titles))
+	for i, title := range titles {
+		escaped[i] = markdownEscaper.Replace(title)
+		separator[i] = "---"
+	}
+	writeMarkdownRow(w, escaped)
+	writeMarkdownRow(w, separator)
+}
+
+// markdownTableRenderer は全データを Markdown の表で出力します
+type markdownTableRenderer struct {
+	w             *errWriter
+	trimSpaces    bool
+	headerWritten bool
+}
+
+func (r *markdownTableRenderer) Begin(headers []string) error {
+	if headers != nil {
+		writeMarkdownTableHeader(r.w, headers)
+		r.headerWritten = true
+	}
+	return r.w.err
+}
+
+func (r *markdownTableRenderer) Row(row *DiffRow) error {
+	if !r.headerWritten {
+		// Markdown の表には見出し行が必要なため、ヘッダー未指定時は列番号を見出しにする
+		titles := make([]string, len(row.Cells))
+		for i := range titles {
+			titles[i] = fmt.Sprintf("Col %d", i+1)
+		}
+		writeMarkdownTableHeader(r.w, titles)
+		r.headerWritten = true
+	}
+
+	outputCells := make([]string, len(row.Cells))
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if r.trimSpaces {
+			cell.TrimRight()
+		}
+		if cell.IsDiff {
+			outputCells[i] = FormatDiffsToMarkdown(cell.Diffs)
+		} else {
+			outputCells[i] = markdownEscaper.Replace(cell.Value)
+		}
+	}
+	writeMarkdownRow(r.w, outputCells)
+	return r.w.err
+}
+
+func (r *markdownTableRenderer) End() error { return r.w.err }
+
+// markdownListRenderer は差分のあるセルのみを Line, Column, DiffValue の3列の表で出力します
+type markdownListRenderer struct {
+	w              *errWriter
+	diffFoundCount int
+}
+
+func (r *markdownListRenderer) Begin(headers []string) error { return nil }
+
+func (r *markdownListRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if !cell.IsDiff {
+			continue
+		}
+		if r.diffFoundCount == 0 {
+			writeMarkdownTableHeader(r.w, []string{"Line", "Column", "DiffValue"})
+		}
+		r.diffFoundCount++
+
+		writeMarkdownRow(r.w, []string{
+			strconv.Itoa(row.Line),
+			markdownEscaper.Replace(columnLabel(cell)),
+			FormatDiffsToMarkdown(cell.Diffs),
+		})
+	}
+	return r.w.err
+}
+
+func (r *markdownListRenderer) End() error {
+	if r.diffFoundCount == 0 {
+		io.WriteString(r.w, "差分は見つかりませんでした。\n")
+	}
+	return r.w.err
+}
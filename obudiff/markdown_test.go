@@ -0,0 +1,124 @@
+package obudiff
+
+import (
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestFormatDiffsToMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		diffs    []diffmatchpatch.Diff
+		expected string
+	}{
+		{
+			name: "Basic",
+			diffs: []diffmatchpatch.Diff{
+				{Type: diffmatchpatch.DiffEqual, Text: "Price: "},
+				{Type: diffmatchpatch.DiffDelete, Text: "100"},
+				{Type: diffmatchpatch.DiffInsert, Text: "200"},
+			},
+			expected: "Price: ~~100~~**200**",
+		},
+		{
+			name: "Escape",
+			diffs: []diffmatchpatch.Diff{
+				{Type: diffmatchpatch.DiffEqual, Text: "a|b\nc"},
+				{Type: diffmatchpatch.DiffInsert, Text: "*x*<y>"},
+			},
+			// 記号で始まる断片は文字の直後では強調にならないため <ins> で囲む
+			expected: `a\|b<br>c<ins>\*x\*&lt;y&gt;</ins>`,
+		},
+		{
+			name: "PunctuationNextToWord",
+			diffs: []diffmatchpatch.Diff{
+				{Type: diffmatchpatch.DiffEqual, Text: "abc"},
+				{Type: diffmatchpatch.DiffInsert, Text: "(x)"},
+				{Type: diffmatchpatch.DiffEqual, Text: " 品名"},
+				{Type: diffmatchpatch.DiffDelete, Text: "「旧」"},
+				{Type: diffmatchpatch.DiffInsert, Text: "「新」"},
+				{Type: diffmatchpatch.DiffEqual, Text: "です"},
+			},
+			expected: "abc<ins>(x)</ins> 品名<del>「旧」</del><ins>「新」</ins>です",
+		},
+		{
+			name: "PunctuationNextToSpace",
+			diffs: []diffmatchpatch.Diff{
+				{Type: diffmatchpatch.DiffEqual, Text: "a "},
+				{Type: diffmatchpatch.DiffInsert, Text: "(x)"},
+				{Type: diffmatchpatch.DiffEqual, Text: "-b"},
+			},
+			expected: "a **(x)**-b",
+		},
+		{
+			name: "SurroundingSpaces",
+			diffs: []diffmatchpatch.Diff{
+				{Type: diffmatchpatch.DiffEqual, Text: "a"},
+				{Type: diffmatchpatch.DiffInsert, Text: " new "},
+				{Type: diffmatchpatch.DiffDelete, Text: " "},
+			},
+			expected: "a **new** <del> </del>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatDiffsToMarkdown(tt.diffs); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMarkdownOutput(t *testing.T) {
+	t.Run("Table", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "markdown"}, testInputDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "| Col 1 | Col 2 | Col 3 | Col 4 |\n" +
+			"| --- | --- | --- | --- |\n" +
+			"| 1 | Apple | ~~OK~~**NG** | Note ~~1~~**2** |\n" +
+			"| 2 | Banana | OK | Note 3 |\n" +
+			"| 3 | Orange | ~~NG~~**OK** | Price 100 |\n"
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+
+	t.Run("TableWithHeader", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "markdown", Headers: []string{"ID", "Name|Alias"}}, "1,[-a-]")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "| ID | Name\\|Alias |\n| --- | --- |\n| 1 | ~~a~~ |\n"
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "markdown", LightMode: true, Headers: testHeaders}, testInputDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "| Line | Column | DiffValue |\n" +
+			"| --- | --- | --- |\n" +
+			"| 1 | 3:Status | ~~OK~~**NG** |\n" +
+			"| 1 | 4:Memo | Note ~~1~~**2** |\n" +
+			"| 3 | 3:Status | ~~NG~~**OK** |\n"
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+
+	t.Run("ListNoDiff", func(t *testing.T) {
+		out, err := runTest(t, Config{Format: "markdown", LightMode: true}, testInputNoDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "差分は見つかりませんでした。\n" {
+			t.Errorf("unexpected output: %q", out)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package obudiff
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// MarkedCSVReader はワード差分マーカーとCSVのクォートを同時に解釈するリーダーです。
+// 1行を変更前と変更後のテキストに復元してからそれぞれクォートを考慮して分割し、
+// 同じ位置のセル同士を [-old-]{+new+} の形式で組み直します。
+// そのため [-"a,b"-]{+"a,c"+} のようにマーカーがクォートをまたぐ入力や、
+// 1,[-A,B-]{+C,D+},3 のようにマーカーが複数セルにまたがる入力も列の位置を保って扱えます。
+// クォートやマーカーが閉じられていない行は次の行と改行で連結します。
+// maxJoinedLines 行または MaxLineBytes バイトを超えても、あるいは入力の終端まで閉じられない場合は
+// データ中の " や [- などの文字列とみなし、先頭の行だけを連結せずに返します
+type MarkedCSVReader struct {
+	lineScanner
+
+	// Markers は差分マーカーの記法です。nil の場合は DefaultMarkers を使用します
+	Markers *MarkerSyntax
+
+	// Logger は連結を取りやめた行を警告するロガーです (nil の場合は出力しない)
+	Logger *slog.Logger
+}
+
+func NewMarkedCSVReader(r io.Reader) *MarkedCSVReader {
+	return &MarkedCSVReader{lineScanner: lineScanner{scanner: bufio.NewScanner(r)}}
+}
+
+func (r *MarkedCSVReader) Read() ([]string, error) {
+	if !r.scan() {
+		err := r.scanErr()
+		if err == nil {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	start := r.line
+	lines := []string{r.text}
+	size := len(r.text)
+	state := markedLineState{markers: r.Markers.orDefault()}
+	state.scan(r.text)
+	for state.open() && len(lines) < maxJoinedLines && size <= r.maxLineBytes() && r.scan() {
+		lines = append(lines, r.text)
+		size += 1 + len(r.text)
+		state.scan("\n" + r.text)
+	}
+	if err := r.scanErr(); err != nil {
+		return nil, err
+	}
+	if state.open() || size > r.maxLineBytes() {
+		r.unscan(lines[1:])
+		if r.Logger != nil {
+			r.Logger.Warn("クォートまたは差分マーカーが閉じられていないため、後続の行を連結せずに読み込みます", "line", start)
+		}
+		lines = lines[:1]
+	}
+	record, _ := parseMarkedLine(strings.Join(lines, "\n"), r.Markers)
+	return record, nil
+}
+
+// markedLineState は連結中の行を先頭から走査した状態です。
+// 行を連結するたびに全体を解析し直さずに、クォートとマーカーが閉じられたかを判定するために使用します
+type markedLineState struct {
+	markers            *MarkerSyntax
+	closer             string                   // 閉じられていないマーカーの終了記号 (なければ空文字)
+	op                 diffmatchpatch.Operation // 閉じられていないマーカーの種類
+	oldQuote, newQuote quoteState               // 変更前と変更後のテキストそれぞれのクォートの状態
+}
+
+// scan は s を続けて走査します。マーカーの内側の文字は変更前または変更後のテキストにのみ含めます
+func (st *markedLineState) scan(s string) {
+	for i := 0; i < len(s); {
+		if st.closer != "" {
+			if strings.HasPrefix(s[i:], st.closer) {
+				i += len(st.closer)
+				st.closer = ""
+				continue
+			}
+			if st.op == diffmatchpatch.DiffDelete {
+				st.oldQuote.scan(s[i])
+			} else {
+				st.newQuote.scan(s[i])
+			}
+			i++
+			continue
+		}
+		if pair, op, ok := st.markers.opener(s[i:]); ok {
+			st.closer, st.op = pair.Close, op
+			i += len(pair.Open)
+			continue
+		}
+		st.oldQuote.scan(s[i])
+		st.newQuote.scan(s[i])
+		i++
+	}
+}
+
+// open はクォートまたはマーカーが閉じられていない場合に true を返します
+func (st *markedLineState) open() bool {
+	return st.closer != "" || st.oldQuote.open() || st.newQuote.open()
+}
+
+// quoteState は splitQuotedCSV と同じ規則でクォートの開閉を1文字ずつ追跡します
+type quoteState struct {
+	inQuote  bool
+	quoteEnd bool // クォート内で " を読んだ直後 (次が " ならエスケープ、それ以外なら閉じる)
+	midField bool // フィールドの途中 (" はクォートの開始とみなさない)
+}
+
+func (q *quoteState) scan(c byte) {
+	if q.quoteEnd {
+		q.quoteEnd = false
+		if c == '"' {
+			return
+		}
+		q.inQuote = false
+	}
+	switch {
+	case q.inQuote:
+		q.quoteEnd = c == '"'
+	case c == ',':
+		q.midField = false
+		return
+	case c == '"' && !q.midField:
+		q.inQuote = true
+	}
+	q.midField = true
+}
+
+func (q *quoteState) open() bool {
+	return q.inQuote && !q.quoteEnd
+}
+
+// parseMarkedLine は1行分のテキストを MarkedCSVReader の規則でフィールドに分割します。
+// クォートまたはマーカーが閉じられていない場合は open に true を返します
+func parseMarkedLine(line string, markers *MarkerSyntax) (record []string, open bool) {
+	segments, markerOpen := markers.split(line)
+	oldText, newText := diffTexts(segments)
+	oldFields, oldQuoteOpen := splitQuotedCSV(oldText)
+	newFields, newQuoteOpen := splitQuotedCSV(newText)
+	open = markerOpen || oldQuoteOpen || newQuoteOpen
+
+	switch {
+	case !hasChange(segments):
+		return newFields, open
+	case strings.TrimSpace(oldText) == "":
+		return markers.markRow(newFields, diffmatchpatch.DiffInsert), open
+	case strings.TrimSpace(newText) == "":
+		return markers.markRow(oldFields, diffmatchpatch.DiffDelete), open
+	default:
+		return markers.markChangedRow(oldFields, newFields), open
+	}
+}
+
+// splitQuotedCSV は1行分のCSVをクォートを考慮してフィールドに分割します。
+// "" はクォート内のエスケープとして扱い、フィールド途中の " は通常の文字として扱います (LazyQuotes相当)。
+// クォートが閉じられないまま終端に達した場合は open に true を返します
+func splitQuotedCSV(s string) (fields []string, open bool) {
+	var field strings.Builder
+	inQuote := false
+	fieldStart := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote:
+			if c != '"' {
+				field.WriteByte(c)
+			} else if i+1 < len(s) && s[i+1] == '"' {
+				field.WriteByte('"')
+				i++
+			} else {
+				inQuote = false
+			}
+		case c == ',':
+			fields = append(fields, field.String())
+			field.Reset()
+			fieldStart = true
+			continue
+		case c == '"' && fieldStart:
+			inQuote = true
+		default:
+			field.WriteByte(c)
+		}
+		fieldStart = false
+	}
+	fields = append(fields, field.String())
+	return fields, inQuote
+}
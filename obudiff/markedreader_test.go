@@ -0,0 +1,213 @@
+package obudiff
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestSplitMarkers(t *testing.T) {
+	segments, open := DefaultMarkers.split("a[-b-]c{+d+}{-e-}")
+	if open {
+		t.Error("open should be false")
+	}
+	expected := []diffmatchpatch.Diff{
+		{Type: diffmatchpatch.DiffEqual, Text: "a"},
+		{Type: diffmatchpatch.DiffDelete, Text: "b"},
+		{Type: diffmatchpatch.DiffEqual, Text: "c"},
+		{Type: diffmatchpatch.DiffInsert, Text: "d"},
+		{Type: diffmatchpatch.DiffDelete, Text: "e"},
+	}
+	if len(segments) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, segments)
+	}
+	for i := range expected {
+		if segments[i] != expected[i] {
+			t.Errorf("segment[%d]: expected %v, got %v", i, expected[i], segments[i])
+		}
+	}
+
+	segments, open = DefaultMarkers.split("x[-y")
+	if !open || len(segments) != 1 || segments[0].Text != "x[-y" {
+		t.Errorf("unclosed marker should be kept as text, got %v (open=%v)", segments, open)
+	}
+}
+
+func TestSplitQuotedCSV(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		open     bool
+	}{
+		{`1,"a,b",c`, []string{"1", "a,b", "c"}, false},
+		{`"a""b",`, []string{`a"b`, ""}, false},
+		{`ab"c,d`, []string{`ab"c`, "d"}, false},
+		{`1,"abc`, []string{"1", "abc"}, true},
+		{``, []string{""}, false},
+	}
+	for _, tt := range tests {
+		fields, open := splitQuotedCSV(tt.input)
+		if strings.Join(fields, "|") != strings.Join(tt.expected, "|") || open != tt.open {
+			t.Errorf("%q: expected %q (open=%v), got %q (open=%v)", tt.input, tt.expected, tt.open, fields, open)
+		}
+	}
+}
+
+func TestMarkedCSVReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected [][]string
+	}{
+		{
+			name:     "MarkerAroundQuotes",
+			input:    `1,[-"a,b"-]{+"a,c"+},x`,
+			expected: [][]string{{"1", "[-a,b-]{+a,c+}", "x"}},
+		},
+		{
+			name:     "MarkerInsideQuotes",
+			input:    `1,"[-a-]{+b+},c",2`,
+			expected: [][]string{{"1", "[-a,c-]{+b,c+}", "2"}},
+		},
+		{
+			name:     "MarkerAcrossCells",
+			input:    `1,[-A,B-]{+C,D+},3`,
+			expected: [][]string{{"1", "[-A-]{+C+}", "[-B-]{+D+}", "3"}},
+		},
+		{
+			name:     "RowAddAndDelete",
+			input:    "{+\"A\",\"B,C\"+}\n[-\"D\",\"\"-]",
+			expected: [][]string{{"{+A+}", "{+B,C+}"}, {"[-D-]", ""}},
+		},
+		{
+			name:     "MultiLineQuoted",
+			input:    "1,\"line1\nline2\",[-x-]{+y+}\n2,z",
+			expected: [][]string{{"1", "line1\nline2", "[-x-]{+y+}"}, {"2", "z"}},
+		},
+		{
+			name:     "NoMarker",
+			input:    `1,"a""b",c`,
+			expected: [][]string{{"1", `a"b`, "c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewMarkedCSVReader(strings.NewReader(tt.input))
+			for i, want := range tt.expected {
+				record, err := reader.Read()
+				if err != nil {
+					t.Fatalf("record[%d]: unexpected error: %v", i, err)
+				}
+				if strings.Join(record, "|") != strings.Join(want, "|") {
+					t.Errorf("record[%d]: expected %q, got %q", i, want, record)
+				}
+			}
+			if _, err := reader.Read(); err != io.EOF {
+				t.Errorf("expected io.EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMarkedCSVReaderUnclosed(t *testing.T) {
+	// 入力の終端まで閉じられないクォートやマーカーはデータ中の文字列とみなし、後続の行を失わない
+	tests := []struct {
+		name     string
+		input    string
+		expected [][]string
+	}{
+		{
+			name:     "Quote",
+			input:    "1,\"abc\n2,x\n3,y",
+			expected: [][]string{{"1", "abc"}, {"2", "x"}, {"3", "y"}},
+		},
+		{
+			name:     "Marker",
+			input:    "1,[-abc\n2,x\n3,{+y+}",
+			expected: [][]string{{"1", "[-abc"}, {"2", "x"}, {"3", "{+y+}"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			reader := NewMarkedCSVReader(strings.NewReader(tt.input))
+			reader.Logger = slog.New(slog.NewTextHandler(&logBuf, nil))
+			for i, want := range tt.expected {
+				record, err := reader.Read()
+				if err != nil {
+					t.Fatalf("record[%d]: unexpected error: %v", i, err)
+				}
+				if strings.Join(record, "|") != strings.Join(want, "|") {
+					t.Errorf("record[%d]: expected %q, got %q", i, want, record)
+				}
+			}
+			if _, err := reader.Read(); err != io.EOF {
+				t.Errorf("expected io.EOF, got %v", err)
+			}
+			if !strings.Contains(logBuf.String(), "line=1") {
+				t.Errorf("unclosed quote or marker should be warned with its line number:\n%s", logBuf.String())
+			}
+		})
+	}
+
+	t.Run("Limit", func(t *testing.T) {
+		// 上限の行数を超えても閉じられない場合は先頭の行だけを返し、後続の行は改めて読み込む
+		input := "1,\"a\n" + strings.Repeat("x\n", maxJoinedLines+10) + "2,b\""
+		reader := NewMarkedCSVReader(strings.NewReader(input))
+		var count int
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count == 0 && strings.Join(record, "|") != "1|a" {
+				t.Errorf("first record: expected [1 a], got %q", record)
+			}
+			count++
+		}
+		if count != maxJoinedLines+12 {
+			t.Errorf("expected %d records, got %d", maxJoinedLines+12, count)
+		}
+	})
+}
+
+func TestMarkedLineState(t *testing.T) {
+	// 1行ずつ走査した結果が、連結した行全体を parseMarkedLine で解析した結果と一致すること
+	for _, input := range []string{
+		`1,"a,b",c`, `1,"abc`, `1,"a""`, `1,"a"""`, `ab"c,d`, `1,[-abc`, `1,[-"a-]{+b+},c`,
+		`1,[-"a-]{+"b+},c`, "1,\"a\nb\",c", "1,[-a\nb-],c", `{+"x+}"`, `[-a-]"b`,
+	} {
+		state := markedLineState{markers: DefaultMarkers}
+		for i, line := range strings.Split(input, "\n") {
+			if i > 0 {
+				line = "\n" + line
+			}
+			state.scan(line)
+		}
+		if _, open := parseMarkedLine(input, nil); state.open() != open {
+			t.Errorf("%q: expected open=%v, got %v", input, open, state.open())
+		}
+	}
+}
+
+func TestMarkedCSVReaderProcessing(t *testing.T) {
+	reader := NewMarkedCSVReader(strings.NewReader(`1,[-"Tokyo, Japan"-]{+"Osaka, Japan"+}`))
+	var out strings.Builder
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	if err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &htmlTableRenderer{w: &errWriter{w: &out}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `<td><del class="diff-del">Tokyo</del><ins class="diff-add">Osaka</ins>, Japan</td>`) {
+		t.Errorf("Missing quoted diff cell:\n%s", out.String())
+	}
+}
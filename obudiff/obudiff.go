@@ -0,0 +1,1023 @@
+// Package obudiff は [-old-]{+new+} などの差分マーカーを含むCSVや diff の出力を解析し、
+// セル単位の差分を強調したCSV・HTML・XLSX・Markdown・JSON に変換します。
+//
+// 入力全体を変換する場合は Process を、2つのCSVを比較する場合は Compare を使用します。
+// 独自の出力形式は Renderer を実装して Render に渡します。
+package obudiff
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/text/transform"
+)
+
+// dmpPool は *diffmatchpatch.DiffMatchPatch オブジェクトをプールします
+var dmpPool = sync.Pool{
+	New: func() interface{} {
+		return diffmatchpatch.New()
+	},
+}
+
+// Config は差分の解析と出力の設定です。ゼロ値はマーカー付きCSVを読み込み、全データをCSVで出力する設定になります
+type Config struct {
+	Format       string   // 出力形式 (csv, html, xlsx, markdown, json, jsonl)。空の場合は csv
+	LightMode    bool     // 差分のあるセルのみをリスト形式で出力する
+	DetailList   bool     // 軽量CSVで変更前・変更後の値を別の列に出力する
+	SideBySide   bool     // HTML出力で変更前と変更後を左右に並べて表示する
+	EnableFilter bool     // HTMLの表に列ごとの絞り込み (JavaScript) を付ける
+	TrimSpaces   bool     // セルの末尾の全角スペースを削除する
+	UseCSVQuote  bool     // 入力を encoding/csv で読み込み、CSVのクォートを解釈する
+	LineLimit    int      // 処理する最大行数 (0の場合は全行)
+	FontFamily   string   // HTML出力の font-family
+	Headers      []string // 出力のヘッダー行 (nil の場合は出力しない)
+	Encoding     string   // 入力の文字コード (空の場合はUTF-8、"auto" の場合は自動判定)
+	ExcelMode    bool     // Excel で開くことを想定した出力にする
+	KeySpec      string   // Compare で行を突き合わせるキー列 (列番号またはヘッダー名のカンマ区切り)
+	FileHeader   bool     // Compare で各入力の先頭行をヘッダー行として扱う
+	SortMemoryMB int      // KeySpec 指定時の外部ソートでメモリに保持するデータ量の目安(MB)
+	TempDir      string   // 一時ファイルを作成するディレクトリ (空の場合はOSの一時ディレクトリ)
+	Jobs         int      // 差分解析を並列に実行するワーカー数 (1以下の場合は逐次処理)
+	MultiLine    bool     // 差分マーカーが行をまたぐ場合に後続行を連結して1レコードとして読み込む
+	MaxLineBytes int      // 1行の最大バイト数 (0の場合は DefaultMaxLineBytes)
+	MarkedCSV    bool     // 差分マーカーとCSVのクォートを同時に解釈して分割する
+	InputFormat  string   // 入力の形式 (csv, unified, word-diff, porcelain)。空の場合は csv
+
+	// OutputEncoding はCSV出力の文字コードです (空の場合はUTF-8)
+	OutputEncoding string
+
+	// Markers は入力の差分マーカーの記法、OutputMarkers はCSV出力で使用する記法です (nil の場合は DefaultMarkers)
+	Markers       *MarkerSyntax
+	OutputMarkers *MarkerSyntax
+
+	// Logger は処理の経過を出力するロガーです (nil の場合は出力しない)
+	Logger *slog.Logger
+}
+
+// logger は Logger を返します。未設定の場合は何も出力しないロガーを返します
+func (cfg Config) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return cfg.Logger
+}
+
+// Validate は設定の値と組み合わせが正しいかを検証します。
+// Process, Compare, NewRenderer は処理を始める前にこれを呼び出し、不正な設定を黙って無視せずにエラーを返します
+func (cfg Config) Validate() error {
+	if cfg.UseCSVQuote && cfg.MarkedCSV {
+		return errors.New("UseCSVQuote (-strict-csv) と MarkedCSV (-marked-csv) は同時に指定できません")
+	}
+	switch cfg.InputFormat {
+	case "", "csv":
+	case "unified", "word-diff", "porcelain":
+		// 差分の入力形式は行単位で読み込み、差分マーカーで行を連結しない
+		if cfg.MultiLine {
+			return fmt.Errorf("MultiLine (-multiline) は入力形式 %s では指定できません", cfg.InputFormat)
+		}
+		// porcelain と unified はマーカーを使わずに差分を読み込む
+		if cfg.InputFormat != "word-diff" && cfg.Markers != nil {
+			return fmt.Errorf("Markers (-markers) は入力形式 %s では指定できません。出力の記法は OutputMarkers (-output-markers) で指定してください", cfg.InputFormat)
+		}
+		// porcelain は単語ごとに区切られた出力で、CSVのクォートを解釈しない
+		if cfg.InputFormat == "porcelain" && (cfg.UseCSVQuote || cfg.MarkedCSV) {
+			return errors.New("UseCSVQuote (-strict-csv) と MarkedCSV (-marked-csv) は入力形式 porcelain では指定できません")
+		}
+	default:
+		return fmt.Errorf("未対応の入力形式です (csv, unified, word-diff, porcelain のいずれかを指定してください): %q", cfg.InputFormat)
+	}
+	// csv.Reader と MarkedCSVReader は独自の規則で行を連結する
+	if cfg.MultiLine && (cfg.UseCSVQuote || cfg.MarkedCSV) {
+		return errors.New("MultiLine (-multiline) は UseCSVQuote (-strict-csv), MarkedCSV (-marked-csv) とは併用できません")
+	}
+	if cfg.MaxLineBytes < 0 {
+		return fmt.Errorf("MaxLineBytes (-max-line-bytes) は0以上で指定してください: %d", cfg.MaxLineBytes)
+	}
+	format := cfg.outputFormat()
+	switch format {
+	case "csv", "html", "xlsx", "markdown", "json", "jsonl":
+	default:
+		return fmt.Errorf("未対応の出力形式です (csv, html, xlsx, markdown, json, jsonl のいずれかを指定してください): %q", cfg.Format)
+	}
+	if cfg.Encoding != "" && cfg.Encoding != AutoEncoding {
+		if _, err := LookupEncoding(cfg.Encoding); err != nil {
+			return fmt.Errorf("入力の文字コードの指定が不正です: %w", err)
+		}
+	}
+	if cfg.OutputEncoding != "" {
+		if format != "csv" {
+			return errors.New("OutputEncoding (-output-encoding) はCSV出力でのみ指定できます")
+		}
+		if _, err := LookupEncoding(cfg.OutputEncoding); err != nil {
+			return fmt.Errorf("出力の文字コードの指定が不正です: %w", err)
+		}
+	}
+	if cfg.DetailList && (!cfg.LightMode || format != "csv") {
+		return errors.New("DetailList (-detail) は LightMode (-light) のCSV出力でのみ指定できます")
+	}
+	if cfg.SideBySide && (format != "html" || cfg.LightMode || cfg.EnableFilter || cfg.ExcelMode) {
+		return errors.New("SideBySide (-side-by-side) は全データ形式のHTML出力でのみ指定でき、LightMode (-light), EnableFilter (-filter), ExcelMode (-excel) とは併用できません")
+	}
+	return nil
+}
+
+// RecordReader はCSVのようなレコード読み込みの抽象化インターフェースです
+type RecordReader interface {
+	Read() ([]string, error)
+}
+
+// cloneRecord は Read が返したレコードのコピーを返します。
+// csv.Reader は ReuseRecord が有効な場合に次の Read で同じスライスを書き換えるため、
+// レコードを次の Read の後まで保持する場合や、他のゴルーチンへ渡す場合はコピーしてから使用します
+func cloneRecord(record []string) []string {
+	return append([]string(nil), record...)
+}
+
+// DefaultMaxLineBytes は Config.MaxLineBytes を指定しない場合の1行の最大バイト数です
+const DefaultMaxLineBytes = 16 << 20
+
+// lineScanner は物理行単位の読み込みと行番号の管理を行います
+type lineScanner struct {
+	scanner *bufio.Scanner
+	started bool
+	line    int
+	text    string   // 直前に読み込んだ行
+	unread  []string // unscan で戻した行 (次の scan で先頭から返す)
+
+	// MaxLineBytes は1行の最大バイト数です。0以下の場合は bufio.MaxScanTokenSize を使用します。
+	// 最初の Read より前に設定してください
+	MaxLineBytes int
+}
+
+// LineTooLongError は1行が MaxLineBytes を超えた場合のエラーです
+type LineTooLongError struct {
+	Line  int // 超過した行の物理行番号 (1始まり)
+	Limit int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("%d 行目が1行の最大長 (%d バイト) を超えています。-max-line-bytes で上限を引き上げてください", e.Line, e.Limit)
+}
+
+func (e *LineTooLongError) Unwrap() error {
+	return bufio.ErrTooLong
+}
+
+// scan は次の物理行を読み込み、行番号を進めます
+func (r *lineScanner) scan() bool {
+	if len(r.unread) > 0 {
+		r.text = r.unread[0]
+		r.unread = r.unread[1:]
+		r.line++
+		return true
+	}
+	if !r.started {
+		r.started = true
+		if r.MaxLineBytes > 0 {
+			r.scanner.Buffer(make([]byte, 0, min(r.MaxLineBytes, 64*1024)), r.MaxLineBytes)
+		}
+	}
+	if !r.scanner.Scan() {
+		return false
+	}
+	r.text = r.scanner.Text()
+	r.line++
+	return true
+}
+
+// unscan は読み込んだ行を戻し、次の scan から再び返すようにします
+func (r *lineScanner) unscan(lines []string) {
+	r.unread = append(slices.Clip(lines), r.unread...)
+	r.line -= len(lines)
+}
+
+// maxLineBytes は1行の最大バイト数を返します
+func (r *lineScanner) maxLineBytes() int {
+	if r.MaxLineBytes <= 0 {
+		return bufio.MaxScanTokenSize
+	}
+	return r.MaxLineBytes
+}
+
+// scanErr は scanner のエラーを返します。行が長すぎる場合は行番号付きの LineTooLongError に変換します
+func (r *lineScanner) scanErr() error {
+	err := r.scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return &LineTooLongError{Line: r.line + 1, Limit: r.maxLineBytes()}
+	}
+	return err
+}
+
+// SimpleCSVReader はクォートを考慮せず単純にカンマで区切るリーダーです
+type SimpleCSVReader struct {
+	lineScanner
+
+	// MultiLine が true の場合、差分マーカー ([-, {-, {+) が閉じられていない行は
+	// 次の行と改行で連結し、1つのレコードとして扱います。
+	// maxJoinedLines 行または MaxLineBytes バイトを超えても、あるいは入力の終端まで閉じられない場合は
+	// データ中の [- などの文字列とみなし、先頭の行だけを連結せずに返します
+	MultiLine bool
+
+	// Logger は連結を取りやめた行を警告するロガーです (nil の場合は出力しない)
+	Logger *slog.Logger
+
+	// Markers は差分マーカーの記法です。nil の場合は DefaultMarkers を使用します
+	Markers *MarkerSyntax
+}
+
+func NewSimpleCSVReader(r io.Reader) *SimpleCSVReader {
+	return &SimpleCSVReader{lineScanner: lineScanner{scanner: bufio.NewScanner(r)}}
+}
+
+func (r *SimpleCSVReader) Read() ([]string, error) {
+	if !r.scan() {
+		err := r.scanErr()
+		if err == nil {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	line := r.text
+
+	if r.MultiLine {
+		joined, err := r.join(line)
+		if err != nil {
+			return nil, err
+		}
+		line = joined
+	}
+	return parseSimpleLine(line, r.Markers), nil
+}
+
+// maxJoinedLines は MultiLine で1つのレコードとして連結する最大の行数です
+const maxJoinedLines = 1000
+
+// join は line の差分マーカーが閉じられていない場合に、閉じられるまで後続の行を改行で連結して返します。
+// 上限を超えても閉じられない場合は読み込んだ後続行を戻し、line をそのまま返します
+func (r *SimpleCSVReader) join(line string) (string, error) {
+	pending := r.Markers.pendingCloser(line, "")
+	if pending == "" {
+		return line, nil
+	}
+	start := r.line
+	lines := []string{line}
+	size := len(line)
+	for pending != "" && len(lines) < maxJoinedLines && size <= r.maxLineBytes() && r.scan() {
+		lines = append(lines, r.text)
+		size += 1 + len(r.text)
+		pending = r.Markers.pendingCloser(r.text, pending)
+	}
+	if err := r.scanErr(); err != nil {
+		return "", err
+	}
+	if pending != "" || size > r.maxLineBytes() {
+		r.unscan(lines[1:])
+		if r.Logger != nil {
+			r.Logger.Warn("差分マーカーが閉じられていないため、後続の行を連結せずに読み込みます", "line", start)
+		}
+		return line, nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// parseSimpleLine は1行分のテキストを SimpleCSVReader の規則でフィールドに分割します
+func parseSimpleLine(line string, markers *MarkerSyntax) []string {
+	// 1. 行全体が追加/削除マーカーで囲まれているかチェック
+	content, isRowAdd, isRowDel := markers.matchRow(line)
+
+	// 2. マーカーがカンマをまたいでいる場合は、変更前と変更後に分けてから分割し、セルごとに組み直す
+	if !isRowAdd && !isRowDel {
+		if segments, _ := markers.split(content); markersCrossCells(segments) {
+			oldText, newText := diffTexts(segments)
+			return markers.markChangedRow(splitSimpleFields(oldText), splitSimpleFields(newText))
+		}
+	}
+
+	// 3. カンマで分割
+	fields := splitSimpleFields(content)
+
+	// 4. 行全体が追加/削除だった場合、各セルにもマーカーを付与
+	for i := range fields {
+		if isRowAdd {
+			fields[i] = markers.mark(fields[i], diffmatchpatch.DiffInsert)
+		} else if isRowDel {
+			fields[i] = markers.mark(fields[i], diffmatchpatch.DiffDelete)
+		}
+	}
+	return fields
+}
+
+// splitSimpleFields はクォートを考慮せずカンマで分割し、各フィールドを囲むクォートを除去します
+func splitSimpleFields(content string) []string {
+	fields := strings.Split(content, ",")
+	for i, field := range fields {
+		if len(field) >= 2 && strings.HasPrefix(field, "\"") && strings.HasSuffix(field, "\"") {
+			fields[i] = field[1 : len(field)-1]
+		}
+	}
+	return fields
+}
+
+// removeBOM はUTF-8のBOMがあれば除去したReaderを返します
+func removeBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	r1, _, err := br.ReadRune()
+	if err != nil {
+		return br
+	}
+	if r1 != '\uFEFF' {
+		br.UnreadRune()
+	}
+	return br
+}
+
+// decodeInput は r を Encoding に従ってデコードし、先頭のBOMを取り除いた Reader を返します
+func decodeInput(r io.Reader, cfg Config) (io.Reader, error) {
+	logger := cfg.logger()
+	encodingName := cfg.Encoding
+	if encodingName == AutoEncoding {
+		br := bufio.NewReaderSize(r, detectSampleSize)
+		// 先読みが途中で終わった場合は入力全体を読み込めている
+		sample, err := br.Peek(detectSampleSize)
+		var confident bool
+		encodingName, confident = detectEncoding(sample, err != nil)
+		if confident {
+			logger.Info("入力の文字コードを判定しました", "encoding", encodingName)
+		} else {
+			logger.Warn("入力の文字コードを確定できませんでした。文字化けする場合は文字コードを指定してください", "encoding", encodingName)
+		}
+		r = br
+	}
+	if encodingName != "" {
+		enc, err := LookupEncoding(encodingName)
+		if err != nil {
+			return nil, err
+		}
+		if enc != nil {
+			logger.Info("入力をデコードします", "encoding", encodingName)
+			r = transform.NewReader(r, enc.NewDecoder())
+		}
+	}
+	return removeBOM(r), nil
+}
+
+// newRecordReader は InputFormat, UseCSVQuote, MarkedCSV などの指定に応じたリーダーを返します。
+// reuseRecord は csv.Reader でレコードのスライスを再利用するかどうかです
+func newRecordReader(r io.Reader, cfg Config, reuseRecord bool) RecordReader {
+	if cfg.InputFormat == "porcelain" {
+		porcelainR := NewPorcelainDiffReader(r)
+		porcelainR.MaxLineBytes = cfg.maxLineBytes()
+		return porcelainR
+	}
+	if cfg.InputFormat == "unified" || cfg.InputFormat == "word-diff" {
+		diffR := NewUnifiedDiffReader(r)
+		diffR.WordDiff = cfg.InputFormat == "word-diff"
+		diffR.QuoteAware = cfg.UseCSVQuote || cfg.MarkedCSV
+		diffR.Markers = cfg.Markers
+		diffR.MaxLineBytes = cfg.maxLineBytes()
+		return diffR
+	}
+	if cfg.UseCSVQuote {
+		csvR := csv.NewReader(r)
+		csvR.ReuseRecord = reuseRecord
+		csvR.LazyQuotes = true
+		return csvR
+	}
+	if cfg.MarkedCSV {
+		markedR := NewMarkedCSVReader(r)
+		markedR.MaxLineBytes = cfg.maxLineBytes()
+		markedR.Markers = cfg.Markers
+		markedR.Logger = cfg.Logger
+		return markedR
+	}
+	simpleR := NewSimpleCSVReader(r)
+	simpleR.MultiLine = cfg.MultiLine
+	simpleR.Logger = cfg.Logger
+	simpleR.Markers = cfg.Markers
+	simpleR.MaxLineBytes = cfg.maxLineBytes()
+	return simpleR
+}
+
+// outputFormat は出力形式を返します。Format が空の場合は csv です
+func (cfg Config) outputFormat() string {
+	if cfg.Format == "" {
+		return "csv"
+	}
+	return cfg.Format
+}
+
+// maxLineBytes はリーダーに設定する1行の最大バイト数を返します
+func (cfg Config) maxLineBytes() int {
+	if cfg.MaxLineBytes == 0 {
+		return DefaultMaxLineBytes
+	}
+	return cfg.MaxLineBytes
+}
+
+// Process は r から InputFormat の形式で差分を読み込み、Format の形式で w に出力します。
+// r は Encoding に従ってデコードし、先頭のBOMを取り除いてから読み込みます
+func Process(ctx context.Context, cfg Config, r io.Reader, w io.Writer) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.KeySpec != "" || cfg.FileHeader {
+		return errors.New("KeySpec (-key) と FileHeader (-file-header) は Compare (-old/-new) でのみ指定できます")
+	}
+	input, err := decodeInput(r, cfg)
+	if err != nil {
+		return err
+	}
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	return executeProcessing(ctx, cfg, newRecordReader(input, cfg, true), w, dmp)
+}
+
+// Render は reader から読み込んだレコードの差分を解析し、結果を renderer に渡します。
+// 独自の出力形式やリーダーを使用する場合に利用します
+func Render(ctx context.Context, cfg Config, reader RecordReader, renderer Renderer) error {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	return render(ctx, newDiffer(cfg, reader, dmp), renderer, cfg.Headers)
+}
+
+// newDiffer は cfg に応じた recordDiffer を作成します
+func newDiffer(cfg Config, reader RecordReader, dmp *diffmatchpatch.DiffMatchPatch) *recordDiffer {
+	differ := newRecordDiffer(reader, dmp, cfg.LineLimit, cfg.Jobs)
+	differ.markers = cfg.Markers
+	if cfg.Jobs > 1 {
+		cfg.logger().Info("差分解析を並列で実行します", "jobs", cfg.Jobs)
+	}
+	return differ
+}
+
+func executeProcessing(ctx context.Context, cfg Config, reader RecordReader, writer io.Writer, dmp *diffmatchpatch.DiffMatchPatch) error {
+	renderer, err := NewRenderer(cfg, writer)
+	if err != nil {
+		return err
+	}
+	return render(ctx, newDiffer(cfg, reader, dmp), renderer, cfg.Headers)
+}
+
+// NewRenderer は cfg の Format と LightMode などの指定に応じた Renderer を作成します
+func NewRenderer(cfg Config, w io.Writer) (Renderer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	logger := cfg.logger()
+	switch format := cfg.outputFormat(); format {
+	case "csv":
+		return newCSVRenderer(cfg, w)
+	case "xlsx":
+		if cfg.LightMode {
+			logger.Info("XLSX形式 (軽量リスト) で処理を開始します...")
+			return &xlsxListRenderer{w: w, logger: logger}, nil
+		}
+		logger.Info("XLSX形式 (全データ) で処理を開始します...")
+		return &xlsxTableRenderer{w: w, trimSpaces: cfg.TrimSpaces, logger: logger}, nil
+	case "json", "jsonl":
+		logger.Info("JSON形式で処理を開始します...", "format", format)
+		return &jsonRenderer{w: &errWriter{w: w}, lines: format == "jsonl"}, nil
+	case "markdown":
+		if cfg.LightMode {
+			logger.Info("Markdown形式 (軽量リスト) で処理を開始します...")
+			return &markdownListRenderer{w: &errWriter{w: w}}, nil
+		}
+		logger.Info("Markdown形式 (全データテーブル) で処理を開始します...")
+		return &markdownTableRenderer{w: &errWriter{w: w}, trimSpaces: cfg.TrimSpaces}, nil
+	case "html":
+		if cfg.LightMode {
+			logger.Info("HTML形式 (軽量リスト) で処理を開始します...")
+			return &htmlListRenderer{w: &errWriter{w: w}, fontFamily: cfg.FontFamily, excelMode: cfg.ExcelMode}, nil
+		}
+		if cfg.SideBySide {
+			logger.Info("HTML形式 (左右比較) で処理を開始します...")
+			return &htmlSideBySideRenderer{w: &errWriter{w: w}, fontFamily: cfg.FontFamily, trimSpaces: cfg.TrimSpaces, tempDir: cfg.TempDir}, nil
+		}
+		logger.Info("HTML形式 (全データテーブル) で処理を開始します...")
+		return &htmlTableRenderer{w: &errWriter{w: w}, fontFamily: cfg.FontFamily, enableFilter: cfg.EnableFilter, trimSpaces: cfg.TrimSpaces, excelMode: cfg.ExcelMode}, nil
+	default:
+		// Validate で検証済みのため到達しない
+		return nil, fmt.Errorf("未対応の出力形式です: %q", cfg.Format)
+	}
+}
+
+// newCSVRenderer はCSV出力の Renderer を作成します。
+// OutputEncoding の指定があれば出力を変換し、ExcelMode の場合は Excel 向けの書き込み先を使用します
+func newCSVRenderer(cfg Config, w io.Writer) (Renderer, error) {
+	logger := cfg.logger()
+	out := &csvOutput{}
+	if cfg.OutputEncoding != "" {
+		enc, err := LookupEncoding(cfg.OutputEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if enc != nil {
+			logger.Info("出力をエンコードします", "encoding", cfg.OutputEncoding)
+			encoded := transform.NewWriter(w, enc.NewEncoder())
+			out.encoded = encoded
+			w = encoded
+		}
+	}
+
+	csvWriter, records, err := newCSVWriter(w, cfg)
+	if err != nil {
+		return nil, err
+	}
+	out.csv = csvWriter
+	if cfg.ExcelMode {
+		logger.Info("Excel向けのCSV (BOM付き・CRLF・数式の無害化) で出力します")
+	}
+	switch {
+	case cfg.LightMode && cfg.DetailList:
+		logger.Info("CSV形式 (軽量リスト・詳細列) で処理を開始します...")
+		out.Renderer = &csvDetailListRenderer{w: records, markers: cfg.OutputMarkers}
+	case cfg.LightMode:
+		logger.Info("CSV形式 (軽量リスト) で処理を開始します...")
+		out.Renderer = &csvListRenderer{w: records, markers: cfg.OutputMarkers}
+	default:
+		logger.Info("CSV形式 (全データ) で処理を開始します...")
+		out.Renderer = &csvFullRenderer{w: records, trimSpaces: cfg.TrimSpaces, markers: cfg.OutputMarkers}
+	}
+	return out, nil
+}
+
+// csvOutput はCSVの Renderer に csv.Writer のフラッシュと出力の文字コード変換の終了処理を加えます。
+// Close はエラーで打ち切った場合にも呼ばれるため、それまでに変換した行は出力されます
+type csvOutput struct {
+	Renderer
+	csv     *csv.Writer
+	encoded io.Closer // OutputEncoding を指定した場合の変換用の Writer
+}
+
+func (o *csvOutput) Close() error {
+	o.csv.Flush()
+	err := o.csv.Error()
+	if o.encoded != nil {
+		if closeErr := o.encoded.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("出力の文字コード変換に失敗: %w", closeErr)
+		}
+	}
+	return err
+}
+
+// csvFullRenderer は全データをCSVで出力します。差分のあるセルはマーカー付きの値になります
+type csvFullRenderer struct {
+	w          recordWriter
+	trimSpaces bool
+	markers    *MarkerSyntax
+}
+
+func (r *csvFullRenderer) Begin(headers []string) error {
+	if headers != nil {
+		if err := r.w.Write(headers); err != nil {
+			return fmt.Errorf("CSVヘッダーの書き込みに失敗: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *csvFullRenderer) Row(row *DiffRow) error {
+	outputRecord := make([]string, len(row.Cells))
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		// 非差分のセルは従来どおり常に末尾の全角スペースを除き、差分のあるセルは -trim 指定時のみトリムする
+		if r.trimSpaces {
+			cell.TrimRight()
+		}
+		if cell.IsDiff {
+			outputRecord[i] = FormatDiffsToText(cell.Diffs, r.markers)
+		} else {
+			outputRecord[i] = strings.TrimRight(cell.Value, "　")
+		}
+	}
+
+	if err := r.w.Write(outputRecord); err != nil {
+		return fmt.Errorf("CSV行の書き込みに失敗 (line %d): %w", row.Line, err)
+	}
+	return nil
+}
+
+func (r *csvFullRenderer) End() error { return nil }
+
+// csvListRenderer は差分のあるセルのみを Line, Column, DiffValue の3列のCSVで出力します
+type csvListRenderer struct {
+	w       recordWriter
+	markers *MarkerSyntax
+}
+
+func (r *csvListRenderer) Begin(headers []string) error {
+	if err := r.w.Write([]string{"Line", "Column", "DiffValue"}); err != nil {
+		return fmt.Errorf("軽量CSVヘッダーの書き込みに失敗: %w", err)
+	}
+	return nil
+}
+
+func (r *csvListRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if !cell.IsDiff {
+			continue
+		}
+		record := []string{
+			strconv.Itoa(row.Line),
+			columnLabel(cell),
+			FormatDiffsToText(cell.Diffs, r.markers),
+		}
+		if err := r.w.Write(record); err != nil {
+			return fmt.Errorf("軽量CSV行の書き込みに失敗 (line %d): %w", row.Line, err)
+		}
+	}
+	return nil
+}
+
+func (r *csvListRenderer) End() error { return nil }
+
+// htmlListRenderer は差分のあるセルのみをHTMLのリストで出力します
+type htmlListRenderer struct {
+	w              *errWriter
+	fontFamily     string
+	excelMode      bool
+	diffFoundCount int
+}
+
+func (r *htmlListRenderer) Begin(headers []string) error {
+	writeHTMLHeaderList(r.w, r.fontFamily)
+	return r.w.err
+}
+
+func (r *htmlListRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if cell.IsDiff {
+			r.diffFoundCount++
+			htmlDiff := FormatDiffsToHTML(cell.Diffs, r.excelMode)
+			writeHTMLDiffLine(r.w, row.Line, cell, htmlDiff)
+		}
+	}
+	return r.w.err
+}
+
+func (r *htmlListRenderer) End() error {
+	if r.diffFoundCount == 0 {
+		io.WriteString(r.w, "<p class='no-diff'>差分は見つかりませんでした。</p>\n")
+	}
+	writeHTMLFooterList(r.w)
+	return r.w.err
+}
+
+// htmlTableRenderer は全データをHTMLの表で出力します
+type htmlTableRenderer struct {
+	w            *errWriter
+	fontFamily   string
+	enableFilter bool
+	trimSpaces   bool
+	excelMode    bool
+}
+
+func (r *htmlTableRenderer) Begin(headers []string) error {
+	writeHTMLHeaderTable(r.w, r.fontFamily, headers, r.enableFilter)
+	io.WriteString(r.w, "<tbody>\n")
+	return r.w.err
+}
+
+func (r *htmlTableRenderer) Row(row *DiffRow) error {
+	outputCells := htmlTableCells(row, r.trimSpaces, r.excelMode)
+	writeHTMLDataRowTable(r.w, outputCells, htmlRowClass(row.Change))
+	return r.w.err
+}
+
+func (r *htmlTableRenderer) End() error {
+	io.WriteString(r.w, "</tbody>\n")
+	writeHTMLFooterTable(r.w, r.enableFilter)
+	return r.w.err
+}
+
+// htmlTableCells は1行分のセルをHTMLに変換します
+func htmlTableCells(row *DiffRow, trimSpaces bool, excelMode bool) []string {
+	outputCells := make([]string, len(row.Cells))
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if trimSpaces {
+			cell.TrimRight()
+		}
+		if cell.IsDiff {
+			outputCells[i] = FormatDiffsToHTML(cell.Diffs, excelMode)
+		} else {
+			outputCells[i] = html.EscapeString(cell.Value)
+		}
+	}
+	return outputCells
+}
+
+// htmlRowClass は行全体が追加・削除の場合にその行に付けるクラス名を返します
+func htmlRowClass(change RowChange) string {
+	switch change {
+	case RowAdded:
+		return "diff-row-add"
+	case RowDeleted:
+		return "diff-row-del"
+	default:
+		return ""
+	}
+}
+
+// errWriter は最初に発生した書き込みエラーを保持し、以降の書き込みを行わない io.Writer です
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.err = err
+	return n, err
+}
+
+// 変更点: Diffリストの末尾から全角スペースを削除する関数
+func trimDiffsRight(diffs []diffmatchpatch.Diff) {
+	if len(diffs) == 0 {
+		return
+	}
+	lastIdx := len(diffs) - 1
+	// 全角スペースのみをトリム
+	diffs[lastIdx].Text = strings.TrimRight(diffs[lastIdx].Text, "　")
+}
+
+func isAllType(diffs []diffmatchpatch.Diff, t diffmatchpatch.Operation) bool {
+	for _, d := range diffs {
+		if d.Type != t {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDiffCell はセル内の [-old-], {-old-}, {+new+} マーカーを解析します。
+// abc[-1-]{+2+}def[-x-]{+y+} のように等価部分と複数の変更が混在するセルは、
+// 変更前と変更後の値を復元してから文字単位の差分を取り直します。
+// マーカーを含まないセルは (nil, false) を返します
+func ParseDiffCell(cell string, dmp *diffmatchpatch.DiffMatchPatch, markers *MarkerSyntax) ([]diffmatchpatch.Diff, bool) {
+	segments, _ := markers.split(cell)
+	return diffSegments(segments, dmp)
+}
+
+// diffSegments はセル1つ分の差分の断片 (等価・削除・追加) を表示用の差分に変換します。
+// 変更を含まない場合は isDiff に false を返します
+func diffSegments(segments []diffmatchpatch.Diff, dmp *diffmatchpatch.DiffMatchPatch) ([]diffmatchpatch.Diff, bool) {
+	if !hasChange(segments) {
+		return nil, false
+	}
+
+	// マーカーの前後にある空白のみの部分は区切りとして扱い、値に含めない
+	segments = trimBlankEdges(segments)
+	if len(segments) == 1 {
+		// 追加のみ・削除のみのセルはそのまま返す
+		return segments, true
+	}
+
+	oldText, newText := diffTexts(segments)
+	diffs := dmp.DiffMain(oldText, newText, false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+	return diffs, true
+}
+
+// FormatDiffsToText は差分を markers の記法のマーカー付きテキストに変換します
+func FormatDiffsToText(diffs []diffmatchpatch.Diff, markers *MarkerSyntax) string {
+	var builder strings.Builder
+	for _, diff := range diffs {
+		builder.WriteString(markers.mark(diff.Text, diff.Type))
+	}
+	return builder.String()
+}
+
+// FormatDiffsToHTML は差分を <del>/<ins> で囲んだHTMLに変換します。excelMode の場合は Excel でも色分けされるよう <font> などを併用します
+func FormatDiffsToHTML(diffs []diffmatchpatch.Diff, excelMode bool) string {
+	var builder strings.Builder
+	for _, diff := range diffs {
+		escapedText := html.EscapeString(diff.Text)
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			builder.WriteString(escapedText)
+		case diffmatchpatch.DiffDelete:
+			if excelMode {
+				// Excel向けのレガシー出力
+				fmt.Fprintf(&builder, `<del class="diff-del" style="background-color: #ffebee;"><font color="#d32f2f"><s>%.*s</s></font></del>`, len(escapedText), escapedText)
+			} else {
+				// ブラウザ向けの標準出力（元に戻す）
+				fmt.Fprintf(&builder, `<del class="diff-del">%.*s</del>`, len(escapedText), escapedText)
+			}
+		case diffmatchpatch.DiffInsert:
+			if excelMode {
+				// Excel向けのレガシー出力
+				fmt.Fprintf(&builder, `<ins class="diff-add" style="background-color: #e8f5e9;"><font color="#388e3c"><b>%.*s</b></font></ins>`, len(escapedText), escapedText)
+			} else {
+				// ブラウザ向けの標準出力（元に戻す）
+				fmt.Fprintf(&builder, `<ins class="diff-add">%.*s</ins>`, len(escapedText), escapedText)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// --- HTMLヘルパー (リストモード) ---
+
+func writeHTMLHeaderList(w io.Writer, fontFamily string) {
+	safeFontFamily := strings.ReplaceAll(fontFamily, "<", "")
+	safeFontFamily = strings.ReplaceAll(safeFontFamily, ">", "")
+	io.WriteString(w, `<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <title>差分比較結果 (不一致リスト)</title>
+    <style>
+`)
+	fmt.Fprintf(w, "        body { font-family: %s; }\n", safeFontFamily)
+	io.WriteString(w, `        .diff-del { color: #d32f2f; text-decoration: line-through; background-color: #ffebee; }
+        .diff-add { color: #388e3c; font-weight: bold; text-decoration: none; background-color: #e8f5e9; }
+        .diff-line { padding: 8px 12px; border-bottom: 1px solid #eee; line-height: 1.5; background-color: #f9f9f9; }
+        .diff-line:nth-child(even) { background-color: #fff; }
+        .diff-line .location { font-weight: bold; color: #555; margin-right: 15px; display: inline-block; min-width: 150px; }
+        .no-diff { font-size: 1.2em; color: #777; padding: 20px; }
+    </style>
+</head>
+<body>
+    <h1>差分比較結果 (不一致のみ)</h1>
+`)
+}
+
+func writeHTMLDiffLine(w io.Writer, line int, cell *DiffCell, htmlDiff string) {
+	io.WriteString(w, "<div class='diff-line'>\n")
+	fmt.Fprintf(w, "    <span class='location'>(Line %d, Col %s)</span>\n", line, html.EscapeString(columnLabel(cell)))
+	fmt.Fprintf(w, "    <span class='value'>%s</span>\n", htmlDiff)
+	io.WriteString(w, "</div>\n")
+}
+
+func writeHTMLFooterList(w io.Writer) {
+	io.WriteString(w, `</body>
+</html>
+`)
+}
+
+// --- HTMLヘルパー (テーブルモード) ---
+
+func writeHTMLHeaderTable(w io.Writer, fontFamily string, headers []string, enableFilter bool) {
+	safeFontFamily := strings.ReplaceAll(fontFamily, "<", "")
+	safeFontFamily = strings.ReplaceAll(safeFontFamily, ">", "")
+	io.WriteString(w, `<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <title>差分比較結果 (全データ)</title>
+    <style>
+`)
+	fmt.Fprintf(w, "        body { font-family: %s; }\n", safeFontFamily)
+	io.WriteString(w, `        .diff-del { color: #d32f2f; text-decoration: line-through; background-color: #ffebee; }
+        .diff-add { color: #388e3c; font-weight: bold; text-decoration: none; background-color: #e8f5e9; }
+        
+        .diff-row-add { background-color: #e6ffed !important; }
+        .diff-row-del { background-color: #ffeef0 !important; }
+
+        table { border-collapse: collapse; margin: 0; font-size: 0.9em; min-width: 100%; }
+        
+        th, td { 
+            border: 1px solid #ccc; 
+            padding: 8px 12px; 
+            vertical-align: top; 
+            text-align: left; 
+            white-space: nowrap; 
+        }
+        th {
+            position: sticky;
+            top: 0;
+            background-color: #f0f0f0;
+            z-index: 10;
+            box-shadow: 0 2px 2px -1px rgba(0, 0, 0, 0.4);
+        }
+        tbody tr:nth-child(odd) { background-color: #f9f9f9; }
+        
+        .table-wrapper {
+            overflow: auto;
+            max-height: 95vh;
+            border: 1px solid #ccc;
+        }
+`)
+	if enableFilter {
+		io.WriteString(w, `
+        .filter-input {
+            width: 100%;
+            box-sizing: border-box;
+            padding: 4px;
+            margin-top: 5px;
+            border: 1px solid #ccc;
+            border-radius: 3px;
+            font-size: 0.9em;
+            font-weight: normal;
+        }
+`)
+	}
+
+	io.WriteString(w, `    </style>
+</head>
+<body>
+    <h1>差分比較結果 (全データ)</h1>
+    <div class="table-wrapper">
+        <table id="diffTable">
+`)
+	if headers != nil {
+		io.WriteString(w, "<thead>\n<tr>\n")
+		for _, h := range headers {
+			fmt.Fprintf(w, "    <th>%s</th>\n", html.EscapeString(h))
+		}
+		io.WriteString(w, "</tr>\n</thead>\n")
+	}
+}
+
+func writeHTMLDataRowTable(w io.Writer, cells []string, rowClass string) {
+	if rowClass != "" {
+		fmt.Fprintf(w, "<tr class=\"%s\">\n", rowClass)
+	} else {
+		io.WriteString(w, "<tr>\n")
+	}
+	for _, c := range cells {
+		fmt.Fprintf(w, "    <td>%s</td>\n", c)
+	}
+	io.WriteString(w, "</tr>\n")
+}
+
+func writeHTMLFooterTable(w io.Writer, enableFilter bool) {
+	io.WriteString(w, `        </table>
+    </div>
+`)
+
+	if enableFilter {
+		io.WriteString(w, `
+<script>
+(function() {
+    const table = document.getElementById("diffTable");
+    if (!table) return;
+
+    const headers = table.querySelectorAll("thead th");
+    if (headers.length === 0) return;
+
+    headers.forEach((th, index) => {
+        const input = document.createElement("input");
+        input.type = "text";
+        input.className = "filter-input";
+        input.placeholder = "Filter...";
+        
+        input.addEventListener("click", function(e) { e.stopPropagation(); });
+        input.addEventListener("input", function() {
+            filterTable();
+        });
+        th.appendChild(document.createElement("br"));
+        th.appendChild(input);
+    });
+
+    function filterTable() {
+        const rows = table.querySelectorAll("tbody tr");
+        const inputs = table.querySelectorAll(".filter-input");
+        const filters = [];
+        inputs.forEach((input, index) => {
+            filters[index] = input.value.toLowerCase();
+        });
+
+        rows.forEach(row => {
+            const cells = row.cells;
+            let shouldShow = true;
+            for (let i = 0; i < filters.length; i++) {
+                const filterText = filters[i];
+                if (!filterText) continue;
+                if (cells[i]) {
+                    const cellText = cells[i].innerText.toLowerCase();
+                    if (!cellText.includes(filterText)) {
+                        shouldShow = false;
+                        break;
+                    }
+                }
+            }
+            row.style.display = shouldShow ? "" : "none";
+        });
+    }
+})();
+</script>
+`)
+	}
+
+	io.WriteString(w, `</body>
+</html>
+`)
+}
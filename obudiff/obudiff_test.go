@@ -1,12 +1,14 @@
-package main
+package obudiff
 
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"io"
 	"log/slog"
+	"slices"
 	"strings"
 	"testing"
 
@@ -64,6 +66,177 @@ func TestSimpleCSVReader(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("CrossCellMarkers", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected []string
+		}{
+			{`1,[-A,B-]{+C,D+},3`, []string{"1", "[-A-]{+C+}", "[-B-]{+D+}", "3"}},
+			{`1,X[-A,B-]{+C+}`, []string{"1", "[-XA-]{+XC+}", "[-B-]"}},
+			{`1,2{+,"3"+}`, []string{"1", "2", "{+3+}"}},
+			{`1,[-A-]{+B+},3`, []string{"1", "[-A-]{+B+}", "3"}},
+		}
+		for _, tt := range tests {
+			reader := NewSimpleCSVReader(strings.NewReader(tt.input))
+			record, err := reader.Read()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(record, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, record)
+			}
+		}
+	})
+
+	t.Run("MultiLine", func(t *testing.T) {
+		input := "1,[-line1\nline2-]{+line1\nline3+},x\n2,{+a\n\nb+}\n3,c"
+		reader := NewSimpleCSVReader(strings.NewReader(input))
+		reader.MultiLine = true
+		expected := [][]string{
+			{"1", "[-line1\nline2-]{+line1\nline3+}", "x"},
+			{"2", "{+a\n\nb+}"},
+			{"3", "c"},
+		}
+		for _, want := range expected {
+			record, err := reader.Read()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(record, "|") != strings.Join(want, "|") {
+				t.Errorf("expected %q, got %q", want, record)
+			}
+		}
+		if _, err := reader.Read(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("MultiLineRowAdd", func(t *testing.T) {
+		input := "{+\"A\",\"B\nC\"+}"
+		reader := NewSimpleCSVReader(strings.NewReader(input))
+		reader.MultiLine = true
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"{+A+}", "{+B\nC+}"}
+		if strings.Join(record, "|") != strings.Join(expected, "|") {
+			t.Errorf("expected %q, got %q", expected, record)
+		}
+	})
+
+	t.Run("MultiLineUnclosed", func(t *testing.T) {
+		// 入力の終端まで閉じられないマーカーはデータ中の文字列とみなし、行を連結しない
+		var logBuf bytes.Buffer
+		reader := NewSimpleCSVReader(strings.NewReader("1,[-a\nb\n2,{+c+}"))
+		reader.MultiLine = true
+		reader.Logger = slog.New(slog.NewTextHandler(&logBuf, nil))
+		got := readAllJoined(t, reader)
+		if expected := []string{"1,[-a", "b", "2,{+c+}"}; !slices.Equal(got, expected) {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+		if !strings.Contains(logBuf.String(), "line=1") {
+			t.Errorf("unclosed marker should be warned with its line number:\n%s", logBuf.String())
+		}
+	})
+
+	t.Run("MultiLineLimit", func(t *testing.T) {
+		// 連結する行数・バイト数の上限を超えた場合は先頭の行だけを返し、後続の行は改めて読み込む
+		for _, tt := range []struct {
+			name         string
+			lines        int
+			maxLineBytes int
+		}{
+			{"Lines", maxJoinedLines + 10, 0},
+			{"Bytes", 20, 32},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				input := "1,[-a\n" + strings.Repeat("x\n", tt.lines) + "2,b-]"
+				reader := NewSimpleCSVReader(strings.NewReader(input))
+				reader.MultiLine = true
+				reader.MaxLineBytes = tt.maxLineBytes
+				got := readAllJoined(t, reader)
+				if len(got) != tt.lines+2 || got[0] != "1,[-a" || got[1] != "x" || got[len(got)-1] != "2,b-]" {
+					t.Errorf("unexpected records: %d records, first %q, last %q", len(got), got[0], got[len(got)-1])
+				}
+			})
+		}
+	})
+}
+
+func TestSimpleCSVReaderMaxLineBytes(t *testing.T) {
+	longCell := strings.Repeat("x", 100*1024)
+	input := "1,short\n2," + longCell + "\n3,short"
+
+	t.Run("DefaultLimit", func(t *testing.T) {
+		reader := NewSimpleCSVReader(strings.NewReader(input))
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := reader.Read()
+		var tooLong *LineTooLongError
+		if !errors.As(err, &tooLong) {
+			t.Fatalf("expected LineTooLongError, got %v", err)
+		}
+		if tooLong.Line != 2 || tooLong.Limit != bufio.MaxScanTokenSize {
+			t.Errorf("unexpected error detail: %+v", tooLong)
+		}
+		if !errors.Is(err, bufio.ErrTooLong) {
+			t.Error("error should wrap bufio.ErrTooLong")
+		}
+	})
+
+	t.Run("RaisedLimit", func(t *testing.T) {
+		reader := NewSimpleCSVReader(strings.NewReader(input))
+		reader.MaxLineBytes = 1 << 20
+		var count int
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			count++
+			if count == 2 && record[1] != longCell {
+				t.Error("long cell was not read correctly")
+			}
+		}
+		if count != 3 {
+			t.Errorf("expected 3 records, got %d", count)
+		}
+	})
+
+	t.Run("MultiLineLineNumber", func(t *testing.T) {
+		reader := NewSimpleCSVReader(strings.NewReader("1,[-a\nb\n" + longCell + "-]"))
+		reader.MultiLine = true
+		reader.MaxLineBytes = 1024
+		_, err := reader.Read()
+		var tooLong *LineTooLongError
+		if !errors.As(err, &tooLong) || tooLong.Line != 3 {
+			t.Errorf("expected LineTooLongError at line 3, got %v", err)
+		}
+	})
+}
+
+func TestMultiLineDiff(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	reader := NewSimpleCSVReader(strings.NewReader("1,[-line1\nline2-]{+line1\nline3+}"))
+	reader.MultiLine = true
+	var outBuf bytes.Buffer
+	csvWriter := csv.NewWriter(&outBuf)
+	if err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &csvFullRenderer{w: csvWriter}, nil); err != nil {
+		t.Fatal(err)
+	}
+	csvWriter.Flush()
+	expected := "1,\"line1\nline[-2-]{+3+}\"\n"
+	if outBuf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, outBuf.String())
+	}
 }
 
 func runTest(t *testing.T, cfg Config, input string) (string, error) {
@@ -76,9 +249,7 @@ func runTest(t *testing.T, cfg Config, input string) (string, error) {
 	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
 	defer dmpPool.Put(dmp)
 
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-
-	err := executeProcessing(cfg, reader, writer, dmp, logger)
+	err := executeProcessing(context.Background(), cfg, reader, writer, dmp)
 
 	flushErr := writer.Flush()
 
@@ -100,7 +271,7 @@ func TestParseDiffCell(t *testing.T) {
 
 	t.Run("Change: [-A-]{+B+}", func(t *testing.T) {
 		cell := "[-old text-]{+new text+}"
-		diffs, isDiff := parseDiffCell(cell, dmp)
+		diffs, isDiff := ParseDiffCell(cell, dmp, nil)
 		if !isDiff {
 			t.Fatal("isDiff should be true")
 		}
@@ -111,7 +282,7 @@ func TestParseDiffCell(t *testing.T) {
 
 	t.Run("Add: {+A+}", func(t *testing.T) {
 		cell := "{+added text+}"
-		diffs, isDiff := parseDiffCell(cell, dmp)
+		diffs, isDiff := ParseDiffCell(cell, dmp, nil)
 		if !isDiff {
 			t.Fatal("isDiff should be true")
 		}
@@ -125,7 +296,7 @@ func TestParseDiffCell(t *testing.T) {
 
 	t.Run("Delete: [-A-]", func(t *testing.T) {
 		cell := "[-deleted text-]"
-		diffs, isDiff := parseDiffCell(cell, dmp)
+		diffs, isDiff := ParseDiffCell(cell, dmp, nil)
 		if !isDiff {
 			t.Fatal("isDiff should be true")
 		}
@@ -139,7 +310,7 @@ func TestParseDiffCell(t *testing.T) {
 
 	t.Run("Delete: {-A-}", func(t *testing.T) {
 		cell := "{-deleted text-}"
-		diffs, isDiff := parseDiffCell(cell, dmp)
+		diffs, isDiff := ParseDiffCell(cell, dmp, nil)
 		if !isDiff {
 			t.Fatal("isDiff should be true")
 		}
@@ -148,9 +319,45 @@ func TestParseDiffCell(t *testing.T) {
 		}
 	})
 
+	t.Run("MultipleChanges", func(t *testing.T) {
+		cell := "abc[-1-]{+2+}def[-x-]{+y+}"
+		diffs, isDiff := ParseDiffCell(cell, dmp, nil)
+		if !isDiff {
+			t.Fatal("isDiff should be true")
+		}
+		if got := FormatDiffsToText(diffs, nil); got != cell {
+			t.Errorf("expected %q, got %q", cell, got)
+		}
+	})
+
+	t.Run("PartialMarkers", func(t *testing.T) {
+		// 等価部分に挟まれた追加・削除も差分として扱い、変更前後の値を再構成する
+		diffs, isDiff := ParseDiffCell("Price: [-100-] yen{+ (tax incl.)+}", dmp, nil)
+		if !isDiff {
+			t.Fatal("isDiff should be true")
+		}
+		oldText, newText := diffTexts(diffs)
+		if oldText != "Price: 100 yen" || newText != "Price:  yen (tax incl.)" {
+			t.Errorf("unexpected reconstruction: old=%q new=%q", oldText, newText)
+		}
+	})
+
+	t.Run("SurroundingSpaces", func(t *testing.T) {
+		diffs, isDiff := ParseDiffCell(" {+added+} ", dmp, nil)
+		if !isDiff || len(diffs) != 1 || diffs[0].Text != "added" {
+			t.Errorf("unexpected diffs: %v", diffs)
+		}
+	})
+
+	t.Run("UnclosedMarker", func(t *testing.T) {
+		if _, isDiff := ParseDiffCell("[-not closed", dmp, nil); isDiff {
+			t.Error("isDiff should be false")
+		}
+	})
+
 	t.Run("NoDiff", func(t *testing.T) {
 		cell := "just normal text"
-		_, isDiff := parseDiffCell(cell, dmp)
+		_, isDiff := ParseDiffCell(cell, dmp, nil)
 		if isDiff {
 			t.Fatal("isDiff should be false")
 		}
@@ -167,7 +374,7 @@ func TestFormatters(t *testing.T) {
 
 	t.Run("FormatText", func(t *testing.T) {
 		expected := "common[-del-]{+add+}<tag>"
-		result := formatDiffsToText(diffs)
+		result := FormatDiffsToText(diffs, nil)
 		if result != expected {
 			t.Errorf("Expected %q, got %q", expected, result)
 		}
@@ -175,7 +382,7 @@ func TestFormatters(t *testing.T) {
 
 	t.Run("FormatHTML", func(t *testing.T) {
 		expected := `common<del class="diff-del">del</del><ins class="diff-add">add</ins>&lt;tag&gt;`
-		result := formatDiffsToHTML(diffs, false)
+		result := FormatDiffsToHTML(diffs, false)
 		if result != expected {
 			t.Errorf("Expected %q, got %q", expected, result)
 		}
@@ -198,16 +405,16 @@ var testHeaders = []string{"ID", "Item", "Status", "Memo"}
 
 // 1. 全データ CSV (-light なし)
 func TestProcessCSVAsFull(t *testing.T) {
-	cfg := Config{LightMode: false, FormatHTML: false}
+	cfg := Config{LightMode: false}
 
 	t.Run("WithDiff_NoHeader", func(t *testing.T) {
 		out, err := runTest(t, cfg, testInputDiff)
 		if err != nil {
 			t.Fatal(err)
 		}
-		expected := `1,Apple,NG[-OK-],Note [-1-]{+2+}
+		expected := `1,Apple,[-OK-]{+NG+},Note [-1-]{+2+}
 2,Banana,OK,Note 3
-3,Orange,OK[-NG-],Price 100
+3,Orange,[-NG-]{+OK+},Price 100
 `
 		if out != expected {
 			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
@@ -257,7 +464,7 @@ func TestProcessCSVAsFull(t *testing.T) {
 		if !strings.HasPrefix(out, "ID,Item,Status,Memo\n") {
 			t.Error("Output should start with header row")
 		}
-		if !strings.Contains(out, "1,Apple,NG[-OK-]") {
+		if !strings.Contains(out, "1,Apple,[-OK-]{+NG+}") {
 			t.Error("Output missing diff data")
 		}
 	})
@@ -269,7 +476,7 @@ func TestProcessCSVAsFull(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		expected := `1,Apple,NG[-OK-],Note [-1-]{+2+}
+		expected := `1,Apple,[-OK-]{+NG+},Note [-1-]{+2+}
 `
 		if out != expected {
 			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
@@ -279,7 +486,7 @@ func TestProcessCSVAsFull(t *testing.T) {
 
 // 2. 全データ HTML (-light なし, -html あり)
 func TestProcessHTMLAsTable(t *testing.T) {
-	cfg := Config{LightMode: false, FormatHTML: true}
+	cfg := Config{LightMode: false, Format: "html"}
 
 	t.Run("WithDiff_NoHeader", func(t *testing.T) {
 		out, err := runTest(t, cfg, testInputDiff)
@@ -295,7 +502,7 @@ func TestProcessHTMLAsTable(t *testing.T) {
 		if !strings.Contains(out, `<td>1</td>`) {
 			t.Error("Missing data for row 1")
 		}
-		if !strings.Contains(out, `<td>NG<ins class="diff-add">OK</ins></td>`) {
+		if !strings.Contains(out, `<td><del class="diff-del">NG</del><ins class="diff-add">OK</ins></td>`) {
 			t.Error("Missing diff for row 3")
 		}
 	})
@@ -354,7 +561,7 @@ func TestProcessHTMLAsTable(t *testing.T) {
 
 // 3. 軽量リスト CSV (-light あり)
 func TestProcessCSVAsList(t *testing.T) {
-	cfg := Config{LightMode: true, FormatHTML: false}
+	cfg := Config{LightMode: true}
 
 	t.Run("WithDiff_NoHeader", func(t *testing.T) {
 		out, err := runTest(t, cfg, testInputDiff)
@@ -362,9 +569,9 @@ func TestProcessCSVAsList(t *testing.T) {
 			t.Fatal(err)
 		}
 		expected := `Line,Column,DiffValue
-1,3,NG[-OK-]
+1,3,[-OK-]{+NG+}
 1,4,Note [-1-]{+2+}
-3,3,OK[-NG-]
+3,3,[-NG-]{+OK+}
 `
 		if out != expected {
 			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
@@ -379,9 +586,9 @@ func TestProcessCSVAsList(t *testing.T) {
 			t.Fatal(err)
 		}
 		expected := `Line,Column,DiffValue
-1,3:Status,NG[-OK-]
+1,3:Status,[-OK-]{+NG+}
 1,4:Memo,Note [-1-]{+2+}
-3,3:Status,OK[-NG-]
+3,3:Status,[-NG-]{+OK+}
 `
 		if out != expected {
 			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
@@ -408,7 +615,21 @@ func TestProcessCSVAsList(t *testing.T) {
 			t.Fatal(err)
 		}
 		expected := `Line,Column,DiffValue
-1,3,NG[-OK-]
+1,3,[-OK-]{+NG+}
+`
+		if out != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+		}
+	})
+
+	t.Run("CrossCellMarkers", func(t *testing.T) {
+		out, err := runTest(t, cfg, `1,[-A,B-]{+C,D+},3`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `Line,Column,DiffValue
+1,2,[-A-]{+C+}
+1,3,[-B-]{+D+}
 `
 		if out != expected {
 			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
@@ -418,7 +639,7 @@ func TestProcessCSVAsList(t *testing.T) {
 
 // 4. 軽量リスト HTML (-light あり, -html あり)
 func TestProcessHTMLAsList(t *testing.T) {
-	cfg := Config{LightMode: true, FormatHTML: true}
+	cfg := Config{LightMode: true, Format: "html"}
 
 	t.Run("WithDiff_NoHeader", func(t *testing.T) {
 		out, err := runTest(t, cfg, testInputDiff)
@@ -487,13 +708,12 @@ func (m *mockErrorWriter) Write(p []byte) (n int, err error) {
 func TestIOErrors(t *testing.T) {
 	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
 	defer dmpPool.Put(dmp)
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	// --- Read エラーのテスト ---
 	t.Run("ReadError_CSVFull", func(t *testing.T) {
 		reader := &mockErrorReader{}
 		writer := csv.NewWriter(io.Discard)
-		err := processCSVAsFull(reader, writer, dmp, 0, nil, false)
+		err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &csvFullRenderer{w: writer}, nil)
 		if err == nil || !strings.Contains(err.Error(), "mock read error") {
 			t.Errorf("Expected read error, got %v", err)
 		}
@@ -501,7 +721,7 @@ func TestIOErrors(t *testing.T) {
 	t.Run("ReadError_CSVList", func(t *testing.T) {
 		reader := &mockErrorReader{}
 		writer := csv.NewWriter(io.Discard)
-		err := processCSVAsList(reader, writer, dmp, 0, nil)
+		err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &csvListRenderer{w: writer}, nil)
 		if err == nil || !strings.Contains(err.Error(), "mock read error") {
 			t.Errorf("Expected read error, got %v", err)
 		}
@@ -509,7 +729,7 @@ func TestIOErrors(t *testing.T) {
 	t.Run("ReadError_HTMLTable", func(t *testing.T) {
 		reader := &mockErrorReader{}
 		writer := bufio.NewWriter(io.Discard)
-		err := processHTMLAsTable(reader, writer, dmp, "", 0, nil, false, false, false)
+		err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &htmlTableRenderer{w: &errWriter{w: writer}}, nil)
 		if err == nil || !strings.Contains(err.Error(), "mock read error") {
 			t.Errorf("Expected read error, got %v", err)
 		}
@@ -517,7 +737,7 @@ func TestIOErrors(t *testing.T) {
 	t.Run("ReadError_HTMLList", func(t *testing.T) {
 		reader := &mockErrorReader{}
 		writer := bufio.NewWriter(io.Discard)
-		err := processHTMLAsList(reader, writer, dmp, "", 0, nil, false)
+		err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 1), &htmlListRenderer{w: &errWriter{w: writer}}, nil)
 		if err == nil || !strings.Contains(err.Error(), "mock read error") {
 			t.Errorf("Expected read error, got %v", err)
 		}
@@ -525,41 +745,144 @@ func TestIOErrors(t *testing.T) {
 
 	// --- Write エラーのテスト ---
 	t.Run("WriteError_CSVFull_Header", func(t *testing.T) {
-		cfg := Config{LightMode: false, FormatHTML: false, Headers: testHeaders}
+		cfg := Config{LightMode: false, Headers: testHeaders}
 		reader := newReader(testInputDiff, false)
 		writer := &mockErrorWriter{}
-		err := executeProcessing(cfg, reader, writer, dmp, logger)
+		err := executeProcessing(context.Background(), cfg, reader, writer, dmp)
 		if err == nil || !strings.Contains(err.Error(), "mock write error") {
 			t.Errorf("Expected write error, got %v", err)
 		}
 	})
 	t.Run("WriteError_CSVFull_Data", func(t *testing.T) {
-		cfg := Config{LightMode: false, FormatHTML: false}
+		cfg := Config{LightMode: false}
 		reader := newReader(testInputDiff, false)
 		writer := &mockErrorWriter{}
-		err := executeProcessing(cfg, reader, writer, dmp, logger)
+		err := executeProcessing(context.Background(), cfg, reader, writer, dmp)
 		if err == nil || !strings.Contains(err.Error(), "mock write error") {
 			t.Errorf("Expected write error, got %v", err)
 		}
 	})
 
 	t.Run("WriteError_CSVList_Header", func(t *testing.T) {
-		cfg := Config{LightMode: true, FormatHTML: false}
+		cfg := Config{LightMode: true}
 		reader := newReader(testInputDiff, false)
 		writer := &mockErrorWriter{}
-		err := executeProcessing(cfg, reader, writer, dmp, logger)
+		err := executeProcessing(context.Background(), cfg, reader, writer, dmp)
 		if err == nil || !strings.Contains(err.Error(), "mock write error") {
 			t.Errorf("Expected write error, got %v", err)
 		}
 	})
 
 	t.Run("WriteError_HTMLList_Header", func(t *testing.T) {
-		cfg := Config{LightMode: true, FormatHTML: true}
+		cfg := Config{LightMode: true, Format: "html"}
 		reader := newReader(testInputDiff, false)
 		writer := &mockErrorWriter{}
-		err := executeProcessing(cfg, reader, writer, dmp, logger)
+		err := executeProcessing(context.Background(), cfg, reader, writer, dmp)
 		if err == nil || !strings.Contains(err.Error(), "mock write error") {
 			t.Errorf("Expected write error, got %v", err)
 		}
 	})
 }
+
+// --- 公開APIのテスト ---
+
+func TestProcess(t *testing.T) {
+	t.Run("DecodeAndRender", func(t *testing.T) {
+		var out bytes.Buffer
+		input := "\uFEFF" + testInputDiff
+		if err := Process(context.Background(), Config{LightMode: true}, strings.NewReader(input), &out); err != nil {
+			t.Fatal(err)
+		}
+		expected := "Line,Column,DiffValue\n1,3,[-OK-]{+NG+}\n1,4,Note [-1-]{+2+}\n3,3,[-NG-]{+OK+}\n"
+		if out.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+		}
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		err := Process(context.Background(), Config{Format: "pdf"}, strings.NewReader(testInputDiff), io.Discard)
+		if err == nil || !strings.Contains(err.Error(), "pdf") {
+			t.Errorf("expected unknown format error, got %v", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := Process(ctx, Config{}, strings.NewReader(testInputDiff), io.Discard)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		{},
+		{Format: "html", SideBySide: true},
+		{LightMode: true, DetailList: true},
+		{Encoding: AutoEncoding, OutputEncoding: "sjis"},
+		{InputFormat: "word-diff", MarkedCSV: true},
+		{InputFormat: "word-diff", Markers: DefaultMarkers},
+		{InputFormat: "unified", UseCSVQuote: true},
+		{MultiLine: true, Markers: DefaultMarkers, MaxLineBytes: 1024},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", cfg, err)
+		}
+	}
+
+	invalid := []struct {
+		name string
+		cfg  Config
+	}{
+		{"UnknownInputFormat", Config{InputFormat: "unifed"}},
+		{"UnknownFormat", Config{Format: "pdf"}},
+		{"QuoteAndMarked", Config{UseCSVQuote: true, MarkedCSV: true}},
+		{"UnknownEncoding", Config{Encoding: "no-such-encoding"}},
+		{"UnknownOutputEncoding", Config{OutputEncoding: "no-such-encoding"}},
+		{"OutputEncodingNotCSV", Config{Format: "json", OutputEncoding: "sjis"}},
+		{"DetailWithoutLight", Config{DetailList: true}},
+		{"DetailNotCSV", Config{Format: "xlsx", LightMode: true, DetailList: true}},
+		{"SideBySideNotHTML", Config{SideBySide: true}},
+		{"SideBySideLight", Config{Format: "html", LightMode: true, SideBySide: true}},
+		{"PorcelainQuote", Config{InputFormat: "porcelain", UseCSVQuote: true}},
+		{"PorcelainMarked", Config{InputFormat: "porcelain", MarkedCSV: true}},
+		{"PorcelainMarkers", Config{InputFormat: "porcelain", Markers: DefaultMarkers}},
+		{"PorcelainMultiLine", Config{InputFormat: "porcelain", MultiLine: true}},
+		{"UnifiedMarkers", Config{InputFormat: "unified", Markers: DefaultMarkers}},
+		{"UnifiedMultiLine", Config{InputFormat: "unified", MultiLine: true}},
+		{"WordDiffMultiLine", Config{InputFormat: "word-diff", MultiLine: true}},
+		{"MultiLineQuote", Config{UseCSVQuote: true, MultiLine: true}},
+		{"MultiLineMarked", Config{MarkedCSV: true, MultiLine: true}},
+		{"NegativeMaxLineBytes", Config{MaxLineBytes: -1}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err == nil {
+				t.Error("expected validation error")
+			}
+			// Process, Compare, NewRenderer も黙って無視せずにエラーを返す
+			if err := Process(context.Background(), tt.cfg, strings.NewReader(testInputDiff), io.Discard); err == nil {
+				t.Error("Process should reject the config")
+			}
+			if err := Compare(context.Background(), tt.cfg, strings.NewReader(testInputDiff), strings.NewReader(testInputDiff), io.Discard); err == nil {
+				t.Error("Compare should reject the config")
+			}
+			if _, err := NewRenderer(tt.cfg, io.Discard); err == nil {
+				t.Error("NewRenderer should reject the config")
+			}
+		})
+	}
+
+	t.Run("ModeSpecific", func(t *testing.T) {
+		if err := Process(context.Background(), Config{KeySpec: "1"}, strings.NewReader(testInputDiff), io.Discard); err == nil {
+			t.Error("Process should reject KeySpec")
+		}
+		cfg := Config{InputFormat: "unified"}
+		if err := Compare(context.Background(), cfg, strings.NewReader("1"), strings.NewReader("1"), io.Discard); err == nil {
+			t.Error("Compare should reject InputFormat")
+		}
+	})
+}
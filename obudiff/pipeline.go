@@ -0,0 +1,257 @@
+package obudiff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffBatchSize は並列処理時に1つのワーカーへまとめて渡すレコード数です
+const diffBatchSize = 256
+
+// LineNumberReader は直前に返したレコードの元ファイル上の行番号を返せるリーダーです。
+// リーダーがこれを実装している場合、出力の行番号にはレコードの連番の代わりにこの値を使用します
+type LineNumberReader interface {
+	RecordReader
+	LineNumber() int
+}
+
+// SegmentReader はセルごとの差分の断片 (等価・削除・追加) を直接返せるリーダーです。
+// リーダーがこれを実装している場合、セルの文字列からマーカーを解析する代わりに Segments の結果を使用するため、
+// データ中に [- や {+ などの文字列が含まれていても差分と誤認しません
+type SegmentReader interface {
+	RecordReader
+	// Segments は直前に返したレコードの各セルに対応する差分の断片を返します
+	Segments() [][]diffmatchpatch.Diff
+}
+
+// contextReader は ctx が取り消されると以降の Read でそのエラーを返すリーダーです。
+// 比較や外部ソートのように、入力をすべて読み込んでから処理するリーダーの入力に使用します
+type contextReader struct {
+	ctx    context.Context
+	reader RecordReader
+}
+
+func (r contextReader) Read() ([]string, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.reader.Read()
+}
+
+// cellDiff は1セル分の差分解析結果です
+type cellDiff struct {
+	diffs  []diffmatchpatch.Diff
+	isDiff bool
+}
+
+// diffedRecord は1レコード分のセルと差分解析結果を保持します
+type diffedRecord struct {
+	line     int
+	cells    []string
+	segments [][]diffmatchpatch.Diff // SegmentReader から読み込んだ場合のみ設定
+	diffs    []cellDiff
+}
+
+// recordDiffer はレコードを読み込み、セルごとの差分解析を行います。
+// jobs が2以上の場合はバッチ単位で複数のワーカーに解析させ、結果を入力順に並べ直して返します
+type recordDiffer struct {
+	reader    RecordReader
+	dmp       *diffmatchpatch.DiffMatchPatch
+	lineLimit int
+	jobs      int
+	markers   *MarkerSyntax // セルの差分マーカーの記法 (nil の場合は DefaultMarkers)
+}
+
+func newRecordDiffer(reader RecordReader, dmp *diffmatchpatch.DiffMatchPatch, lineLimit int, jobs int) *recordDiffer {
+	return &recordDiffer{reader: reader, dmp: dmp, lineLimit: lineLimit, jobs: jobs}
+}
+
+// lineNumber は直前に読み込んだレコードの行番号を返します。
+// リーダーが LineNumberReader を実装していない場合は読み込んだレコードの連番 (lineCount) を返します
+func (d *recordDiffer) lineNumber(lineCount int) int {
+	if lr, ok := d.reader.(LineNumberReader); ok {
+		return lr.LineNumber()
+	}
+	return lineCount
+}
+
+// newRecord は読み込んだレコードから diffedRecord を作成します
+func (d *recordDiffer) newRecord(lineCount int, cells []string) *diffedRecord {
+	rec := &diffedRecord{line: d.lineNumber(lineCount), cells: cells}
+	if sr, ok := d.reader.(SegmentReader); ok {
+		rec.segments = sr.Segments()
+	}
+	return rec
+}
+
+// parse はレコードの各セルの差分を解析します
+func (rec *diffedRecord) parse(dmp *diffmatchpatch.DiffMatchPatch, markers *MarkerSyntax) {
+	if rec.segments == nil {
+		rec.diffs = parseRecord(rec.cells, dmp, markers)
+		return
+	}
+	rec.diffs = make([]cellDiff, len(rec.segments))
+	for i, segments := range rec.segments {
+		rec.diffs[i].diffs, rec.diffs[i].isDiff = diffSegments(segments, dmp)
+	}
+}
+
+// parseRecord はレコードの各セルを ParseDiffCell で解析します
+func parseRecord(record []string, dmp *diffmatchpatch.DiffMatchPatch, markers *MarkerSyntax) []cellDiff {
+	diffs := make([]cellDiff, len(record))
+	for i, cell := range record {
+		diffs[i].diffs, diffs[i].isDiff = ParseDiffCell(cell, dmp, markers)
+	}
+	return diffs
+}
+
+// each は入力順に各レコードの解析結果を fn に渡します。
+// fn がエラーを返した場合や ctx が取り消された場合はその時点で処理を打ち切り、そのエラーを返します
+func (d *recordDiffer) each(ctx context.Context, fn func(rec *diffedRecord) error) error {
+	if d.jobs <= 1 {
+		return d.eachSerial(ctx, fn)
+	}
+	return d.eachParallel(ctx, fn)
+}
+
+func (d *recordDiffer) eachSerial(ctx context.Context, fn func(rec *diffedRecord) error) error {
+	var lineCount int
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.lineLimit > 0 && lineCount >= d.lineLimit {
+			return nil
+		}
+		record, err := d.reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("CSV行の読み取りに失敗 (line %d): %w", lineCount+1, err)
+		}
+		lineCount++
+
+		rec := d.newRecord(lineCount, record)
+		rec.parse(d.dmp, d.markers)
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// diffBatch は並列処理の単位です。done はワーカーが解析を終えると閉じられます
+type diffBatch struct {
+	records []*diffedRecord
+	err     error
+	done    chan struct{}
+}
+
+func (d *recordDiffer) eachParallel(ctx context.Context, fn func(rec *diffedRecord) error) error {
+	work := make(chan *diffBatch)
+	// ordered には読み込んだ順にバッチが積まれ、出力側はこの順で結果を待つ
+	ordered := make(chan *diffBatch, d.jobs*2)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range d.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+			defer dmpPool.Put(dmp)
+			for batch := range work {
+				for _, rec := range batch.records {
+					rec.parse(dmp, d.markers)
+				}
+				close(batch.done)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(work)
+		defer close(ordered)
+		d.readBatches(ctx, work, ordered, stop)
+	}()
+
+	var err error
+	for batch := range ordered {
+		if err != nil {
+			// 打ち切り後は残りのバッチを読み捨てて読み込み側の終了を待つ
+			continue
+		}
+		<-batch.done
+		for _, rec := range batch.records {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if err = fn(rec); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = batch.err
+		}
+		if err != nil {
+			close(stop)
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// readBatches はレコードをバッチ単位に読み込み、ワーカーと出力側の両方へ渡します。
+// 読み込みエラーや ctx の取り消しは読み込めた分のレコードとともにバッチに格納して最後に渡します
+func (d *recordDiffer) readBatches(ctx context.Context, work, ordered chan<- *diffBatch, stop <-chan struct{}) {
+	var lineCount int
+	for {
+		batch := &diffBatch{done: make(chan struct{})}
+		for len(batch.records) < diffBatchSize {
+			if err := ctx.Err(); err != nil {
+				batch.err = err
+				break
+			}
+			if d.lineLimit > 0 && lineCount >= d.lineLimit {
+				batch.err = io.EOF
+				break
+			}
+			record, err := d.reader.Read()
+			if err == io.EOF {
+				batch.err = io.EOF
+				break
+			}
+			if err != nil {
+				batch.err = fmt.Errorf("CSV行の読み取りに失敗 (line %d): %w", lineCount+1, err)
+				break
+			}
+			lineCount++
+			batch.records = append(batch.records, d.newRecord(lineCount, cloneRecord(record)))
+		}
+
+		last := batch.err != nil
+		if batch.err == io.EOF {
+			batch.err = nil
+		}
+
+		select {
+		case ordered <- batch:
+		case <-stop:
+			return
+		}
+		select {
+		case work <- batch:
+		case <-stop:
+			return
+		}
+		if last {
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package obudiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// mockFailAfterReader は指定件数のレコードを返した後にエラーを返します
+type mockFailAfterReader struct {
+	reader RecordReader
+	count  int
+}
+
+func (m *mockFailAfterReader) Read() ([]string, error) {
+	if m.count == 0 {
+		return nil, errors.New("mock read error")
+	}
+	m.count--
+	return m.reader.Read()
+}
+
+func largeDiffInput(rows int) string {
+	var sb strings.Builder
+	for i := 1; i <= rows; i++ {
+		if i%3 == 0 {
+			fmt.Fprintf(&sb, "%d,Item %d,[-old %d-]{+new %d+},{+added+}\n", i, i, i, i*2)
+		} else {
+			fmt.Fprintf(&sb, "%d,Item %d,same,\n", i, i)
+		}
+	}
+	return sb.String()
+}
+
+func TestRecordDifferParallel(t *testing.T) {
+	input := largeDiffInput(diffBatchSize*5 + 17)
+
+	for _, cfg := range []Config{
+		{},
+		{LightMode: true},
+		{Format: "html"},
+		{Format: "html", LightMode: true},
+	} {
+		t.Run(fmt.Sprintf("light=%v,html=%v", cfg.LightMode, cfg.outputFormat() == "html"), func(t *testing.T) {
+			serial, err := runTest(t, cfg, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Jobs = 4
+			parallel, err := runTest(t, cfg, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if serial != parallel {
+				t.Error("parallel output differs from serial output")
+			}
+		})
+	}
+
+	t.Run("LineLimit", func(t *testing.T) {
+		cfg := Config{LightMode: true, Jobs: 4, LineLimit: diffBatchSize + 2}
+		out, err := runTest(t, cfg, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(out, fmt.Sprintf("%d,4,{+added+}\n", diffBatchSize+2)) {
+			t.Errorf("unexpected tail of output: %q", out[len(out)-40:])
+		}
+		if strings.Contains(out, fmt.Sprintf("\n%d,", diffBatchSize+5)) {
+			t.Error("output should stop at the line limit")
+		}
+	})
+}
+
+func TestRecordDifferErrors(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	t.Run("ReadError", func(t *testing.T) {
+		reader := &mockFailAfterReader{reader: newReader(largeDiffInput(1000), false), count: 600}
+		differ := newRecordDiffer(reader, dmp, 0, 4)
+		var seen int
+		err := differ.each(context.Background(), func(rec *diffedRecord) error {
+			seen++
+			if rec.line != seen {
+				t.Fatalf("records out of order: got line %d, want %d", rec.line, seen)
+			}
+			return nil
+		})
+		if err == nil || !strings.Contains(err.Error(), "(line 601): mock read error") {
+			t.Errorf("Expected read error at line 601, got %v", err)
+		}
+		if seen != 600 {
+			t.Errorf("expected 600 records before the error, got %d", seen)
+		}
+	})
+
+	t.Run("CallbackError", func(t *testing.T) {
+		differ := newRecordDiffer(newReader(largeDiffInput(5000), false), dmp, 0, 4)
+		stopErr := errors.New("stop")
+		err := differ.each(context.Background(), func(rec *diffedRecord) error {
+			if rec.line == 300 {
+				return stopErr
+			}
+			return nil
+		})
+		if err != stopErr {
+			t.Errorf("Expected callback error, got %v", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		for _, jobs := range []int{1, 4} {
+			ctx, cancel := context.WithCancel(context.Background())
+			differ := newRecordDiffer(newReader(largeDiffInput(5000), false), dmp, 0, jobs)
+			var seen int
+			err := differ.each(ctx, func(rec *diffedRecord) error {
+				seen++
+				if rec.line == 300 {
+					cancel()
+				}
+				return nil
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("jobs=%d: expected context.Canceled, got %v", jobs, err)
+			}
+			if seen != 300 {
+				t.Errorf("jobs=%d: expected processing to stop after line 300, got %d records", jobs, seen)
+			}
+		}
+	})
+
+	t.Run("WriteError", func(t *testing.T) {
+		cfg := Config{Format: "html", Jobs: 4}
+		err := executeProcessing(context.Background(), cfg, newReader(largeDiffInput(2000), false), &mockErrorWriter{}, dmp)
+		if err == nil || !strings.Contains(err.Error(), "mock write error") {
+			t.Errorf("Expected write error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package obudiff
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// PorcelainDiffReader は git diff --word-diff=porcelain の出力からレコードを読み込むリーダーです。
+// ハンク内の各行は先頭の1文字が " " (等価), "-" (削除), "+" (追加) の単語で、
+// "~" のみの行が元の行の区切りを表します。
+// 差分の種類を行頭の記号で受け取るため、データ中に [- や {+ などの文字列が含まれていても誤認しません。
+// SegmentReader を実装しており、Read が返すセルは変更後の値です
+type PorcelainDiffReader struct {
+	lineScanner
+
+	inHunk       bool
+	oldLine      int // 次に現れる旧ファイルの行番号
+	newLine      int // 次に現れる新ファイルの行番号
+	pending      []diffmatchpatch.Diff
+	cellSegments [][]diffmatchpatch.Diff
+	lineNumber   int
+}
+
+func NewPorcelainDiffReader(r io.Reader) *PorcelainDiffReader {
+	return &PorcelainDiffReader{lineScanner: lineScanner{scanner: bufio.NewScanner(r)}}
+}
+
+func (r *PorcelainDiffReader) Read() ([]string, error) {
+	for r.scan() {
+		line := r.text
+		if m := hunkHeaderRegex.FindStringSubmatch(line); m != nil {
+			r.oldLine, _, r.newLine, _ = parseHunkHeader(m)
+			r.inHunk = true
+			r.pending = nil
+			continue
+		}
+		if strings.HasPrefix(line, "diff --git ") {
+			r.inHunk = false
+			continue
+		}
+		if !r.inHunk || line == "" {
+			continue
+		}
+
+		switch line[0] {
+		case ' ':
+			r.pending = append(r.pending, diffmatchpatch.Diff{Type: diffmatchpatch.DiffEqual, Text: line[1:]})
+		case '-':
+			r.pending = append(r.pending, diffmatchpatch.Diff{Type: diffmatchpatch.DiffDelete, Text: line[1:]})
+		case '+':
+			r.pending = append(r.pending, diffmatchpatch.Diff{Type: diffmatchpatch.DiffInsert, Text: line[1:]})
+		case '~':
+			if record := r.endLine(); record != nil {
+				return record, nil
+			}
+		}
+	}
+	if err := r.scanErr(); err != nil {
+		return nil, err
+	}
+	// "~" で終わらないまま入力が終わった場合も最後の行として扱う
+	if record := r.endLine(); record != nil {
+		return record, nil
+	}
+	return nil, io.EOF
+}
+
+// endLine は保留中の単語を1行分のレコードにまとめ、行番号を進めます。
+// 単語のない空行の場合は nil を返します
+func (r *PorcelainDiffReader) endLine() []string {
+	segments := r.pending
+	r.pending = nil
+
+	deleteOnly, insertOnly := len(segments) > 0, len(segments) > 0
+	for _, d := range segments {
+		deleteOnly = deleteOnly && d.Type == diffmatchpatch.DiffDelete
+		insertOnly = insertOnly && d.Type == diffmatchpatch.DiffInsert
+	}
+	switch {
+	case deleteOnly:
+		r.lineNumber = r.oldLine
+		r.oldLine++
+	case insertOnly:
+		r.lineNumber = r.newLine
+		r.newLine++
+	default:
+		r.lineNumber = r.newLine
+		r.oldLine++
+		r.newLine++
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	var cells []string
+	cells, r.cellSegments = splitSegmentCells(segments)
+	return cells
+}
+
+// LineNumber は直前に返したレコードの元ファイル上の行番号を返します
+func (r *PorcelainDiffReader) LineNumber() int {
+	return r.lineNumber
+}
+
+// Segments は直前に返したレコードの各セルの差分の断片を返します
+func (r *PorcelainDiffReader) Segments() [][]diffmatchpatch.Diff {
+	return r.cellSegments
+}
@@ -0,0 +1,85 @@
+package obudiff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// testPorcelainDiff は git diff --word-diff=porcelain の出力例です (データ中に [-1-] を含む)
+const testPorcelainDiff = `diff --git a/f.csv b/f.csv
+index 0f07790..7e5e066 100644
+--- a/f.csv
++++ b/f.csv
+@@ -1,4 +1,4 @@
+ 5,Apple,OK
+~
+-6,Banana,OK
+~
+ 7,Orange,
+-OK
++NG
+~
+ 8,P[-1-],
+-x
++{+y+}
+~
++9,New,z
+~
+`
+
+func TestPorcelainDiffReader(t *testing.T) {
+	reader := NewPorcelainDiffReader(strings.NewReader(testPorcelainDiff))
+	var got []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		segments := reader.Segments()
+		if len(segments) != len(record) {
+			t.Fatalf("segments and cells differ in length: %d vs %d", len(segments), len(record))
+		}
+		var cells []string
+		for _, segs := range segments {
+			cells = append(cells, FormatDiffsToText(segs, nil))
+		}
+		got = append(got, fmt.Sprintf("%d:%s", reader.LineNumber(), strings.Join(cells, "|")))
+	}
+
+	expected := []string{
+		"1:5|Apple|OK",
+		"2:[-6-]|[-Banana-]|[-OK-]",
+		"2:7|Orange|[-OK-]{+NG+}",
+		"3:8|P[-1-]|[-x-]{+{+y+}+}",
+		"4:{+9+}|{+New+}|{+z+}",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestPorcelainDiffProcessing(t *testing.T) {
+	reader := NewPorcelainDiffReader(strings.NewReader(testPorcelainDiff))
+	var out strings.Builder
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	if err := render(context.Background(), newRecordDiffer(reader, dmp, 0, 2), &htmlTableRenderer{w: &errWriter{w: &out}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+	// データ中の [-1-] は差分として扱われず、そのままの文字列として出力される
+	if !strings.Contains(html, "<td>P[-1-]</td>") {
+		t.Errorf("Literal marker text should be kept as data:\n%s", html)
+	}
+	if !strings.Contains(html, `<td><del class="diff-del">x</del><ins class="diff-add">{+y+}</ins></td>`) {
+		t.Errorf("Missing changed cell:\n%s", html)
+	}
+}
@@ -0,0 +1,116 @@
+package obudiff
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// RowChange は行全体としての変更の種類です
+type RowChange int
+
+const (
+	RowUnchanged RowChange = iota // 差分のあるセルがない
+	RowModified                   // 一部のセルに差分がある
+	RowAdded                      // 空でないセルがすべて追加 (行の追加)
+	RowDeleted                    // 空でないセルがすべて削除 (行の削除)
+)
+
+// DiffCell は1セル分の差分解析結果です
+type DiffCell struct {
+	Column    int                   // 0始まりの列番号
+	Header    string                // 列のヘッダー名 (ヘッダー未指定の場合は空)
+	HasHeader bool                  // ヘッダーにこの列がある (ヘッダー名が空文字の場合も true)
+	Value     string                // 入力されたセルの値
+	Diffs     []diffmatchpatch.Diff // 表示用の差分 (IsDiff が false の場合は nil)
+	IsDiff    bool
+}
+
+// TrimRight はセルの値と差分の末尾にある全角スペースを取り除きます (-trim 用)
+func (c *DiffCell) TrimRight() {
+	c.Value = strings.TrimRight(c.Value, "　")
+	trimDiffsRight(c.Diffs)
+}
+
+// DiffRow は1レコード分の差分解析結果です
+type DiffRow struct {
+	Line   int // 入力上の行番号
+	Cells  []DiffCell
+	Change RowChange
+}
+
+// Renderer は差分解析結果を出力形式に変換します。
+// Begin, 入力順の各行に対する Row, End の順に呼ばれ、いずれかがエラーを返すとそこで処理を打ち切ります。
+// 一時ファイルなどの後始末が必要な場合は io.Closer も実装してください。Close はエラーの有無にかかわらず最後に呼ばれます
+type Renderer interface {
+	Begin(headers []string) error
+	Row(row *DiffRow) error
+	End() error
+}
+
+// render は differ の解析結果を順に r へ渡します。ctx が取り消された場合はその時点で打ち切り、End は呼びません
+func render(ctx context.Context, differ *recordDiffer, r Renderer, headers []string) (err error) {
+	if c, ok := r.(io.Closer); ok {
+		defer func() {
+			if closeErr := c.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	if err := r.Begin(headers); err != nil {
+		return err
+	}
+	err = differ.each(ctx, func(rec *diffedRecord) error {
+		return r.Row(newDiffRow(rec, headers))
+	})
+	if err != nil {
+		return err
+	}
+	return r.End()
+}
+
+// newDiffRow は diffedRecord から Renderer に渡す行を作成します
+func newDiffRow(rec *diffedRecord, headers []string) *DiffRow {
+	row := &DiffRow{Line: rec.line, Cells: make([]DiffCell, len(rec.cells))}
+	isRowAdd, isRowDel, hasDiff := true, true, false
+	for i, cell := range rec.cells {
+		c := DiffCell{Column: i, Value: cell, Diffs: rec.diffs[i].diffs, IsDiff: rec.diffs[i].isDiff}
+		if i < len(headers) {
+			c.Header, c.HasHeader = headers[i], true
+		}
+		row.Cells[i] = c
+
+		if c.IsDiff {
+			hasDiff = true
+			isRowAdd = isRowAdd && isAllType(c.Diffs, diffmatchpatch.DiffInsert)
+			isRowDel = isRowDel && isAllType(c.Diffs, diffmatchpatch.DiffDelete)
+		} else if cell != "" {
+			isRowAdd, isRowDel = false, false
+		}
+	}
+
+	switch {
+	case !hasDiff:
+		row.Change = RowUnchanged
+	case isRowAdd:
+		row.Change = RowAdded
+	case isRowDel:
+		row.Change = RowDeleted
+	default:
+		row.Change = RowModified
+	}
+	return row
+}
+
+// columnLabel は軽量リスト形式で使用する列の表示名 (列番号、ヘッダーがあれば "列番号:ヘッダー名") を返します。
+// ヘッダー名が空の列も "列番号:" とします
+func columnLabel(c *DiffCell) string {
+	if c.HasHeader {
+		return strconv.Itoa(c.Column+1) + ":" + c.Header
+	}
+	return strconv.Itoa(c.Column + 1)
+}
@@ -0,0 +1,98 @@
+package obudiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// recordingRenderer は受け取ったイベントを文字列として記録する Renderer です
+type recordingRenderer struct {
+	events []string
+	rowErr error
+	closed bool
+}
+
+func (r *recordingRenderer) Begin(headers []string) error {
+	r.events = append(r.events, "begin:"+strings.Join(headers, "|"))
+	return nil
+}
+
+func (r *recordingRenderer) Row(row *DiffRow) error {
+	var cells []string
+	for _, c := range row.Cells {
+		cells = append(cells, fmt.Sprintf("%d:%s:%v", c.Column, c.Header, c.IsDiff))
+	}
+	r.events = append(r.events, fmt.Sprintf("row %d %d %s", row.Line, row.Change, strings.Join(cells, ",")))
+	return r.rowErr
+}
+
+func (r *recordingRenderer) End() error {
+	r.events = append(r.events, "end")
+	return nil
+}
+
+func (r *recordingRenderer) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	t.Run("Events", func(t *testing.T) {
+		input := testInputDiff + "\n{+4+},{+Peach+},{+OK+},\n[-5-],[-Melon-],,"
+		r := &recordingRenderer{}
+		if err := render(context.Background(), newRecordDiffer(newReader(input, false), dmp, 0, 1), r, []string{"ID", "Item"}); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			"begin:ID|Item",
+			fmt.Sprintf("row 1 %d 0:ID:false,1:Item:false,2::true,3::true", RowModified),
+			fmt.Sprintf("row 2 %d 0:ID:false,1:Item:false,2::false,3::false", RowUnchanged),
+			fmt.Sprintf("row 3 %d 0:ID:false,1:Item:false,2::true,3::false", RowModified),
+			fmt.Sprintf("row 4 %d 0:ID:true,1:Item:true,2::true,3::false", RowAdded),
+			fmt.Sprintf("row 5 %d 0:ID:true,1:Item:true,2::false,3::false", RowDeleted),
+			"end",
+		}
+		if strings.Join(r.events, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(r.events, "\n"))
+		}
+		if !r.closed {
+			t.Error("Close should be called")
+		}
+	})
+
+	t.Run("RowError", func(t *testing.T) {
+		rowErr := errors.New("row error")
+		r := &recordingRenderer{rowErr: rowErr}
+		err := render(context.Background(), newRecordDiffer(newReader(testInputDiff, false), dmp, 0, 2), r, nil)
+		if !errors.Is(err, rowErr) {
+			t.Errorf("expected row error, got %v", err)
+		}
+		if len(r.events) != 2 || r.events[len(r.events)-1] == "end" {
+			t.Errorf("End should not be called after an error: %q", r.events)
+		}
+		if !r.closed {
+			t.Error("Close should be called even after an error")
+		}
+	})
+}
+
+func TestColumnLabel(t *testing.T) {
+	// ヘッダー名が空の列も、ヘッダーがない列と区別して "列番号:" とする
+	var out strings.Builder
+	cfg := Config{LightMode: true, Headers: []string{"ID", ""}}
+	if err := Process(context.Background(), cfg, strings.NewReader("1,[-a-]{+b+},[-c-]{+d+}"), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Line,Column,DiffValue\n1,2:,[-a-]{+b+}\n1,3,[-c-]{+d+}\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,200 @@
+package obudiff
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+)
+
+// htmlSideBySideRenderer は変更前と変更後を左右に並べて表示するHTMLを出力します。
+// 左右の表は同じ行を同じ高さで表示し、スクロール位置を同期します。
+// 画面上のボタンで、従来のインライン表示 (1つの表に削除と追加を並べる) と切り替えられます。
+// 右側の表は左側をすべて書き終えてから出力する必要があるため、tempDir の一時ファイルに書き出しておきます
+type htmlSideBySideRenderer struct {
+	w          *errWriter
+	fontFamily string
+	trimSpaces bool
+	tempDir    string
+
+	tmp     *os.File
+	newPane *errWriter
+}
+
+func (r *htmlSideBySideRenderer) Begin(headers []string) error {
+	tmp, err := os.CreateTemp(r.tempDir, "obudiff-sbs-*.html")
+	if err != nil {
+		return fmt.Errorf("一時ファイルの作成に失敗: %w", err)
+	}
+	r.tmp = tmp
+	r.newPane = &errWriter{w: tmp}
+
+	writeHTMLHeaderSideBySide(r.w, r.fontFamily)
+	writeHTMLPaneHeader(r.w, "oldPane", "変更前", headers)
+	writeHTMLPaneHeader(r.newPane, "newPane", "変更後", headers)
+	return r.err()
+}
+
+func (r *htmlSideBySideRenderer) Row(row *DiffRow) error {
+	// セルには削除と追加の両方を出力し、どちらを表示するかは表ごとのCSSで切り替える
+	outputCells := htmlTableCells(row, r.trimSpaces, false)
+	rowClass := htmlRowClass(row.Change)
+	writeHTMLPaneRow(r.w, row.Line, outputCells, rowClass)
+	writeHTMLPaneRow(r.newPane, row.Line, outputCells, rowClass)
+	return r.err()
+}
+
+func (r *htmlSideBySideRenderer) End() error {
+	writeHTMLPaneFooter(r.w)
+	writeHTMLPaneFooter(r.newPane)
+	if err := r.err(); err != nil {
+		return err
+	}
+	if _, err := r.tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("一時ファイルの読み込みに失敗: %w", err)
+	}
+	if _, err := io.Copy(r.w, r.tmp); err != nil && r.w.err == nil {
+		return fmt.Errorf("一時ファイルの読み込みに失敗: %w", err)
+	}
+	writeHTMLFooterSideBySide(r.w)
+	return r.w.err
+}
+
+// Close は一時ファイルを削除します
+func (r *htmlSideBySideRenderer) Close() error {
+	if r.tmp == nil {
+		return nil
+	}
+	r.tmp.Close()
+	if err := os.Remove(r.tmp.Name()); err != nil {
+		return fmt.Errorf("一時ファイルの削除に失敗: %w", err)
+	}
+	return nil
+}
+
+// err は出力先または一時ファイルへの書き込みで発生した最初のエラーを返します
+func (r *htmlSideBySideRenderer) err() error {
+	if r.newPane.err != nil {
+		return fmt.Errorf("一時ファイルへの書き込みに失敗: %w", r.newPane.err)
+	}
+	return r.w.err
+}
+
+func writeHTMLHeaderSideBySide(w io.Writer, fontFamily string) {
+	safeFontFamily := strings.ReplaceAll(fontFamily, "<", "")
+	safeFontFamily = strings.ReplaceAll(safeFontFamily, ">", "")
+	io.WriteString(w, `<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <title>差分比較結果 (左右比較)</title>
+    <style>
+`)
+	fmt.Fprintf(w, "        body { font-family: %s; margin: 8px; }\n", safeFontFamily)
+	io.WriteString(w, `        .diff-del { color: #d32f2f; text-decoration: line-through; background-color: #ffebee; }
+        .diff-add { color: #388e3c; font-weight: bold; text-decoration: none; background-color: #e8f5e9; }
+
+        .diff-row-add { background-color: #e6ffed !important; }
+        .diff-row-del { background-color: #ffeef0 !important; }
+
+        .toolbar { margin-bottom: 8px; }
+        .toolbar button { padding: 4px 12px; font-size: 0.9em; cursor: pointer; }
+
+        .panes { display: flex; gap: 8px; }
+        .pane { flex: 1 1 50%; min-width: 0; overflow: auto; max-height: 90vh; border: 1px solid #ccc; }
+        .pane-title { position: sticky; left: 0; margin: 0; padding: 4px 12px; font-size: 1em; background-color: #fafafa; }
+
+        table { border-collapse: collapse; margin: 0; font-size: 0.9em; min-width: 100%; }
+        th, td {
+            border: 1px solid #ccc;
+            padding: 8px 12px;
+            vertical-align: top;
+            text-align: left;
+            white-space: nowrap;
+        }
+        th {
+            position: sticky;
+            top: 0;
+            background-color: #f0f0f0;
+            z-index: 10;
+            box-shadow: 0 2px 2px -1px rgba(0, 0, 0, 0.4);
+        }
+        td.line-no { color: #888; text-align: right; background-color: #f5f5f5; }
+        tbody tr:nth-child(odd) { background-color: #f9f9f9; }
+
+        /* 左右比較: 変更前には削除を、変更後には追加のみを表示する */
+        #oldPane .diff-add { display: none; }
+        #newPane .diff-del { display: none; }
+        #oldPane .diff-row-add, #newPane .diff-row-del { background-color: #eeeeee !important; }
+
+        /* インライン表示: 変更前の表に削除と追加を並べて表示する */
+        body.inline-view #newPane { display: none; }
+        body.inline-view #oldPane .diff-add { display: inline; }
+        body.inline-view #oldPane .diff-row-add { background-color: #e6ffed !important; }
+        body.inline-view .pane-title { display: none; }
+    </style>
+</head>
+<body>
+    <h1>差分比較結果 (左右比較)</h1>
+    <div class="toolbar"><button type="button" id="toggleView">インライン表示に切り替え</button></div>
+    <div class="panes">
+`)
+}
+
+// writeHTMLPaneHeader は左右どちらかの表の開始部分を書き込みます
+func writeHTMLPaneHeader(w io.Writer, id, title string, headers []string) {
+	fmt.Fprintf(w, "<div class=\"pane\" id=\"%s\">\n<h2 class=\"pane-title\">%s</h2>\n<table>\n", id, title)
+	if headers != nil {
+		io.WriteString(w, "<thead>\n<tr>\n    <th>#</th>\n")
+		for _, h := range headers {
+			fmt.Fprintf(w, "    <th>%s</th>\n", html.EscapeString(h))
+		}
+		io.WriteString(w, "</tr>\n</thead>\n")
+	}
+	io.WriteString(w, "<tbody>\n")
+}
+
+// writeHTMLPaneRow は行番号を先頭に付けて1行分のセルを書き込みます (セルはエスケープ済みであること)
+func writeHTMLPaneRow(w io.Writer, line int, cells []string, rowClass string) {
+	if rowClass != "" {
+		fmt.Fprintf(w, "<tr class=\"%s\">\n", rowClass)
+	} else {
+		io.WriteString(w, "<tr>\n")
+	}
+	fmt.Fprintf(w, "    <td class=\"line-no\">%d</td>\n", line)
+	for _, c := range cells {
+		fmt.Fprintf(w, "    <td>%s</td>\n", c)
+	}
+	io.WriteString(w, "</tr>\n")
+}
+
+func writeHTMLPaneFooter(w io.Writer) {
+	io.WriteString(w, "</tbody>\n</table>\n</div>\n")
+}
+
+func writeHTMLFooterSideBySide(w io.Writer) {
+	io.WriteString(w, `    </div>
+<script>
+(function() {
+    const panes = [document.getElementById("oldPane"), document.getElementById("newPane")];
+    panes.forEach((pane, index) => {
+        const other = panes[1 - index];
+        pane.addEventListener("scroll", function() {
+            // 値が同じ場合は代入しないことで、相手側のscrollイベントとの往復を防ぐ
+            if (other.scrollTop !== pane.scrollTop) other.scrollTop = pane.scrollTop;
+            if (other.scrollLeft !== pane.scrollLeft) other.scrollLeft = pane.scrollLeft;
+        });
+    });
+
+    const button = document.getElementById("toggleView");
+    button.addEventListener("click", function() {
+        const inline = document.body.classList.toggle("inline-view");
+        button.textContent = inline ? "左右比較に切り替え" : "インライン表示に切り替え";
+    });
+})();
+</script>
+</body>
+</html>
+`)
+}
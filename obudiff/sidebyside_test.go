@@ -0,0 +1,55 @@
+package obudiff
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTMLSideBySide(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := Config{Format: "html", SideBySide: true, Headers: testHeaders, TempDir: tempDir}
+	out, err := runTest(t, cfg, testInputDiff+"\n{+4+},{+Peach+},{+<new>+},")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldStart := strings.Index(out, `<div class="pane" id="oldPane">`)
+	newStart := strings.Index(out, `<div class="pane" id="newPane">`)
+	if oldStart < 0 || newStart < oldStart {
+		t.Fatalf("panes not found in order:\n%s", out)
+	}
+	oldPane, newPane := out[oldStart:newStart], out[newStart:]
+
+	for _, pane := range []string{oldPane, newPane} {
+		for _, want := range []string{
+			"<th>#</th>",
+			"<th>Status</th>",
+			`<td class="line-no">3</td>`,
+			`<del class="diff-del">OK</del><ins class="diff-add">NG</ins>`,
+			`<tr class="diff-row-add">`,
+			`<ins class="diff-add">&lt;new&gt;</ins>`,
+		} {
+			if !strings.Contains(pane, want) {
+				t.Errorf("pane should contain %q", want)
+			}
+		}
+		if n := strings.Count(pane, "<tr"); n != 5 {
+			t.Errorf("expected 5 rows (header + 4) per pane, got %d", n)
+		}
+	}
+
+	for _, want := range []string{`id="toggleView"`, "inline-view", "scrollTop", "</html>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q", want)
+		}
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("temporary files should be removed: %v", entries)
+	}
+}
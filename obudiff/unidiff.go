@@ -0,0 +1,245 @@
+package obudiff
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// hunkHeaderRegex は "@@ -開始行,行数 +開始行,行数 @@" 形式のハンクヘッダーです
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// numberedLine は元ファイル上の行番号付きの1行です
+type numberedLine struct {
+	text string
+	line int
+}
+
+// numberedRecord は元ファイル上の行番号付きのレコードです
+type numberedRecord struct {
+	cells []string
+	line  int
+}
+
+// segmentedRecord は元ファイル上の行番号とセルごとの差分の断片を持つレコードです
+type segmentedRecord struct {
+	numberedRecord
+	segments [][]diffmatchpatch.Diff
+}
+
+// UnifiedDiffReader は unified diff (diff -u や git diff の出力) からレコードを読み込むリーダーです。
+// diff --git や ---/+++ などのヘッダーは読み飛ばし、ハンク内の連続する削除行と追加行を
+// 先頭から順に対応付けて、セル単位で比較します。
+// 対応する相手のない削除行・追加行は行全体の削除・追加になります。
+// SegmentReader を実装しており、Read が返すセルは変更後の値です。
+// 削除行と追加行の比較結果をマーカーを介さずに渡すため、データ中の [- や {+ などの文字列を差分と誤認しません。
+// LineNumber はハンクヘッダーから求めた元ファイル上の行番号を返します (削除行は旧ファイル、それ以外は新ファイルの行番号)
+type UnifiedDiffReader struct {
+	lineScanner
+
+	// WordDiff が true の場合、git diff --word-diff=plain の出力として読み込みます。
+	// ハンク内の各行を [-old-]{+new+} のマーカーを含む1レコードとして扱います
+	WordDiff bool
+
+	// QuoteAware が true の場合、各行をクォートを考慮して分割します (-marked-csv 相当)
+	QuoteAware bool
+
+	// Markers は差分マーカーの記法です。nil の場合は DefaultMarkers を使用します
+	Markers *MarkerSyntax
+
+	inHunk     bool
+	oldLine    int // 次に現れる旧ファイルの行番号
+	newLine    int // 次に現れる新ファイルの行番号
+	oldRemain  int
+	newRemain  int
+	removed    []numberedLine
+	added      []numberedLine
+	queue      []segmentedRecord
+	lineNumber int
+	segments   [][]diffmatchpatch.Diff
+}
+
+func NewUnifiedDiffReader(r io.Reader) *UnifiedDiffReader {
+	return &UnifiedDiffReader{lineScanner: lineScanner{scanner: bufio.NewScanner(r)}}
+}
+
+func (r *UnifiedDiffReader) Read() ([]string, error) {
+	for len(r.queue) == 0 {
+		if !r.scan() {
+			if err := r.scanErr(); err != nil {
+				return nil, err
+			}
+			r.flushChanges()
+			if len(r.queue) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		r.handleLine(r.text)
+	}
+
+	rec := r.queue[0]
+	r.queue = r.queue[1:]
+	r.lineNumber = rec.line
+	r.segments = rec.segments
+	return rec.cells, nil
+}
+
+// LineNumber は直前に返したレコードの元ファイル上の行番号を返します
+func (r *UnifiedDiffReader) LineNumber() int {
+	return r.lineNumber
+}
+
+// Segments は直前に返したレコードの各セルの差分の断片を返します
+func (r *UnifiedDiffReader) Segments() [][]diffmatchpatch.Diff {
+	return r.segments
+}
+
+// enqueue は旧行と新行を比較したレコードを line 行目として返却待ちに加えます。
+// 行全体の追加・削除の場合は oldRecord または newRecord に nil を渡します
+func (r *UnifiedDiffReader) enqueue(line int, oldRecord, newRecord []string) {
+	cells, segments := compareRow(oldRecord, newRecord)
+	r.queue = append(r.queue, segmentedRecord{numberedRecord{cells: cells, line: line}, segments})
+}
+
+func (r *UnifiedDiffReader) handleLine(line string) {
+	if !r.inHunk || r.WordDiff {
+		if m := hunkHeaderRegex.FindStringSubmatch(line); m != nil {
+			r.flushChanges()
+			r.startHunk(m)
+			return
+		}
+	}
+	if !r.inHunk {
+		// ファイルヘッダーなどハンク外の行は読み飛ばす
+		return
+	}
+
+	if r.WordDiff {
+		if strings.HasPrefix(line, "diff --git ") {
+			r.inHunk = false
+			return
+		}
+		r.handleWordDiffLine(line)
+		return
+	}
+
+	prefix, text := byte(' '), line
+	if line != "" {
+		prefix, text = line[0], line[1:]
+	}
+	switch prefix {
+	case ' ':
+		r.flushChanges()
+		fields := r.splitFields(text)
+		r.enqueue(r.newLine, fields, fields)
+		r.oldLine++
+		r.newLine++
+		r.oldRemain--
+		r.newRemain--
+	case '-':
+		r.removed = append(r.removed, numberedLine{text: text, line: r.oldLine})
+		r.oldLine++
+		r.oldRemain--
+	case '+':
+		r.added = append(r.added, numberedLine{text: text, line: r.newLine})
+		r.newLine++
+		r.newRemain--
+	case '\\':
+		// "\ No newline at end of file" は無視する
+	default:
+		// 想定外の行はハンクの終わりとみなす
+		r.flushChanges()
+		r.inHunk = false
+		return
+	}
+
+	if r.oldRemain <= 0 && r.newRemain <= 0 {
+		r.flushChanges()
+		r.inHunk = false
+	}
+}
+
+// startHunk はハンクヘッダーの行番号と行数を読み取り、ハンクの処理を開始します
+func (r *UnifiedDiffReader) startHunk(m []string) {
+	r.oldLine, r.oldRemain, r.newLine, r.newRemain = parseHunkHeader(m)
+	r.inHunk = true
+}
+
+// parseHunkHeader は hunkHeaderRegex の一致結果から旧・新ファイルの開始行と行数を返します。
+// 行数が省略されている場合は1とみなします
+func parseHunkHeader(m []string) (oldLine, oldCount, newLine, newCount int) {
+	atoi := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	return atoi(m[1]), atoi(m[2]), atoi(m[3]), atoi(m[4])
+}
+
+// handleWordDiffLine はワード差分形式の1行をレコードにします。
+// 行全体が削除の場合は旧ファイル、それ以外は新ファイルの行番号を割り当てます
+func (r *UnifiedDiffReader) handleWordDiffLine(line string) {
+	segments, _ := r.Markers.split(line)
+	oldText, newText := diffTexts(segments)
+	oldBlank := strings.TrimSpace(oldText) == ""
+	newBlank := strings.TrimSpace(newText) == ""
+
+	var cells []string
+	if r.QuoteAware {
+		cells, _ = parseMarkedLine(line, r.Markers)
+	} else {
+		cells = parseSimpleLine(line, r.Markers)
+	}
+	// ワード差分ではマーカーが入力の書式そのものなので、各セルのマーカーを解析して差分の断片にする
+	rec := segmentedRecord{numberedRecord: numberedRecord{cells: cells}, segments: make([][]diffmatchpatch.Diff, len(cells))}
+	for i, cell := range cells {
+		rec.segments[i], _ = r.Markers.split(cell)
+		_, cells[i] = diffTexts(rec.segments[i])
+	}
+
+	switch {
+	case hasChange(segments) && newBlank && !oldBlank:
+		rec.line = r.oldLine
+		r.oldLine++
+	case hasChange(segments) && oldBlank && !newBlank:
+		rec.line = r.newLine
+		r.newLine++
+	default:
+		rec.line = r.newLine
+		r.oldLine++
+		r.newLine++
+	}
+	r.queue = append(r.queue, rec)
+}
+
+// flushChanges は保留中の削除行と追加行を先頭から順に対応付けてレコードにします
+func (r *UnifiedDiffReader) flushChanges() {
+	for i := range max(len(r.removed), len(r.added)) {
+		switch {
+		case i < len(r.removed) && i < len(r.added):
+			r.enqueue(r.added[i].line, r.splitFields(r.removed[i].text), r.splitFields(r.added[i].text))
+		case i < len(r.removed):
+			r.enqueue(r.removed[i].line, r.splitFields(r.removed[i].text), nil)
+		default:
+			r.enqueue(r.added[i].line, nil, r.splitFields(r.added[i].text))
+		}
+	}
+	r.removed = r.removed[:0]
+	r.added = r.added[:0]
+}
+
+// splitFields は diff の1行 (マーカーを含まないCSVの1行) をフィールドに分割します
+func (r *UnifiedDiffReader) splitFields(text string) []string {
+	if r.QuoteAware {
+		fields, _ := splitQuotedCSV(text)
+		return fields
+	}
+	return splitSimpleFields(text)
+}
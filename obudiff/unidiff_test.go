@@ -0,0 +1,160 @@
+package obudiff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const testUnifiedDiff = `diff --git a/fruits.csv b/fruits.csv
+index 1234567..89abcde 100644
+--- a/fruits.csv
++++ b/fruits.csv
+@@ -10,4 +10,5 @@ header
+ 10,Apple,OK
+-11,Banana,OK
++11,Banana,NG
+ 12,Orange,OK
+-13,Grape,OK
++14,Melon,OK
++15,Peach,NG
+@@ -30,2 +31,1 @@
+-30,Lemon,OK
+ 31,Lime,OK
+\ No newline at end of file
+`
+
+const testWordDiff = `diff --git a/fruits.csv b/fruits.csv
+index 1234567..89abcde 100644
+--- a/fruits.csv
++++ b/fruits.csv
+@@ -5,3 +5,3 @@
+5,Apple,OK
+[-6,Banana,OK-]
+{+6,Cherry,OK+}
+7,Orange,[-OK-]{+NG+}
+`
+
+// readAllNumbered は全レコードを "行番号:セル|セル|..." の形式で返します。
+// 各セルは差分の断片をマーカー付きテキストにしたものです
+func readAllNumbered(t *testing.T, reader *UnifiedDiffReader) []string {
+	t.Helper()
+	var got []string
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var cells []string
+		for _, segments := range reader.Segments() {
+			cells = append(cells, FormatDiffsToText(segments, nil))
+		}
+		got = append(got, fmt.Sprintf("%d:%s", reader.LineNumber(), strings.Join(cells, "|")))
+	}
+}
+
+func TestUnifiedDiffReader(t *testing.T) {
+	t.Run("Unified", func(t *testing.T) {
+		got := readAllNumbered(t, NewUnifiedDiffReader(strings.NewReader(testUnifiedDiff)))
+		expected := []string{
+			"10:10|Apple|OK",
+			"11:11|Banana|[-OK-]{+NG+}",
+			"12:12|Orange|OK",
+			"13:[-13-]{+14+}|[-Grape-]{+Melon+}|OK",
+			"14:{+15+}|{+Peach+}|{+NG+}",
+			"30:[-30-]|[-Lemon-]|[-OK-]",
+			"31:31|Lime|OK",
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+	})
+
+	t.Run("QuoteAware", func(t *testing.T) {
+		reader := NewUnifiedDiffReader(strings.NewReader("@@ -1 +1 @@\n-1,\"Tokyo, Japan\"\n+1,\"Osaka, Japan\"\n"))
+		reader.QuoteAware = true
+		got := readAllNumbered(t, reader)
+		if len(got) != 1 || got[0] != "1:1|[-Tokyo, Japan-]{+Osaka, Japan+}" {
+			t.Errorf("unexpected records: %q", got)
+		}
+	})
+
+	t.Run("WordDiff", func(t *testing.T) {
+		reader := NewUnifiedDiffReader(strings.NewReader(testWordDiff))
+		reader.WordDiff = true
+		got := readAllNumbered(t, reader)
+		expected := []string{
+			"5:5|Apple|OK",
+			"6:[-6-]|[-Banana-]|[-OK-]",
+			"6:{+6+}|{+Cherry+}|{+OK+}",
+			"7:7|Orange|[-OK-]{+NG+}",
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+	})
+}
+
+func TestUnifiedDiffMarkerSyntaxInContent(t *testing.T) {
+	// diff の内容に含まれる [- や +} などの文字列を差分マーカーと誤認しない
+	input := "@@ -1,2 +1,2 @@\n a[-b-]c,{+x\n-1,p-]q,r\n+1,p-]q,s+}t\n"
+	got := readAllNumbered(t, NewUnifiedDiffReader(strings.NewReader(input)))
+	expected := []string{
+		"1:a[-b-]c|{+x",
+		"2:1|p-]q|[-r-]{+s+}t+}",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	var out strings.Builder
+	cfg := Config{Format: "jsonl", InputFormat: "unified"}
+	if err := Process(context.Background(), cfg, strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON := `{"line":2,"column":3,"old":"r","new":"s+}t","segments":[{"op":"delete","text":"r"},{"op":"insert","text":"s+}t"}]}` + "\n"
+	if out.String() != expectedJSON {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expectedJSON, out.String())
+	}
+}
+
+func TestUnifiedDiffProcessing(t *testing.T) {
+	cfg := Config{LightMode: true, InputFormat: "unified"}
+	reader := newRecordReader(strings.NewReader(testUnifiedDiff), cfg, true)
+
+	var outBuf bytes.Buffer
+	writer := bufio.NewWriter(&outBuf)
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	if err := executeProcessing(context.Background(), cfg, reader, writer, dmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Line,Column,DiffValue
+11,3,[-OK-]{+NG+}
+13,1,1[-3-]{+4+}
+13,2,[-Grape-]{+Melon+}
+14,1,{+15+}
+14,2,{+Peach+}
+14,3,{+NG+}
+30,1,[-30-]
+30,2,[-Lemon-]
+30,3,[-OK-]
+`
+	if outBuf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, outBuf.String())
+	}
+}
@@ -0,0 +1,329 @@
+package obudiff
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// MarkerPair は差分マーカーの開始記号と終了記号の組です
+type MarkerPair struct {
+	Open, Close string
+}
+
+// MarkerSyntax は差分マーカーの記法です。
+// 解析時は Delete と Insert のすべての組を認識し、マーカーを付与する際はそれぞれ先頭の組を使用します。
+// nil の場合は DefaultMarkers として扱います
+type MarkerSyntax struct {
+	Delete []MarkerPair
+	Insert []MarkerPair
+
+	openers string         // 開始記号の先頭文字の集合 (走査の高速化用)
+	rowAdd  *regexp.Regexp // 行全体が追加マーカーで囲まれている行
+	rowDel  *regexp.Regexp // 行全体が削除マーカーで囲まれている行
+}
+
+// DefaultMarkers は git diff --word-diff=plain や wdiff と同じ [-old-], {-old-}, {+new+} の記法です
+var DefaultMarkers = NewMarkerSyntax([]MarkerPair{{"[-", "-]"}, {"{-", "-}"}}, []MarkerPair{{"{+", "+}"}})
+
+// NewMarkerSyntax は削除・追加それぞれのマーカーの組から記法を作成します
+func NewMarkerSyntax(del, ins []MarkerPair) *MarkerSyntax {
+	m := &MarkerSyntax{Delete: del, Insert: ins}
+	var openers strings.Builder
+	for _, pair := range slices.Concat(del, ins) {
+		r, _ := utf8.DecodeRuneInString(pair.Open)
+		if !strings.ContainsRune(openers.String(), r) {
+			openers.WriteRune(r)
+		}
+	}
+	m.openers = openers.String()
+	m.rowAdd = rowMarkerRegex(ins)
+	m.rowDel = rowMarkerRegex(del)
+	return m
+}
+
+// ParseMarkerSyntax は "削除開始 削除終了 追加開始 追加終了" のように
+// 空白で区切った4つの記号から記法を作成します (例: "<<del>> <</del>> <<ins>> <</ins>>")
+func ParseMarkerSyntax(spec string) (*MarkerSyntax, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("マーカーは \"削除開始 削除終了 追加開始 追加終了\" の4つを空白区切りで指定してください: %q", spec)
+	}
+	if strings.HasPrefix(fields[0], fields[2]) || strings.HasPrefix(fields[2], fields[0]) {
+		return nil, fmt.Errorf("削除と追加の開始記号は区別できるものを指定してください: %q, %q", fields[0], fields[2])
+	}
+	return NewMarkerSyntax([]MarkerPair{{fields[0], fields[1]}}, []MarkerPair{{fields[2], fields[3]}}), nil
+}
+
+// rowMarkerRegex は行全体がいずれかのマーカーの組で囲まれている行に一致する正規表現を作成します
+func rowMarkerRegex(pairs []MarkerPair) *regexp.Regexp {
+	alts := make([]string, len(pairs))
+	for i, pair := range pairs {
+		alts[i] = regexp.QuoteMeta(pair.Open) + "(.*)" + regexp.QuoteMeta(pair.Close)
+	}
+	return regexp.MustCompile(`(?s)^\s*(?:` + strings.Join(alts, "|") + `)\s*$`)
+}
+
+func (m *MarkerSyntax) orDefault() *MarkerSyntax {
+	if m == nil {
+		return DefaultMarkers
+	}
+	return m
+}
+
+// opener は s の先頭にある開始記号とその種類を返します
+func (m *MarkerSyntax) opener(s string) (pair MarkerPair, op diffmatchpatch.Operation, ok bool) {
+	for _, pair := range m.Delete {
+		if strings.HasPrefix(s, pair.Open) {
+			return pair, diffmatchpatch.DiffDelete, true
+		}
+	}
+	for _, pair := range m.Insert {
+		if strings.HasPrefix(s, pair.Open) {
+			return pair, diffmatchpatch.DiffInsert, true
+		}
+	}
+	return MarkerPair{}, 0, false
+}
+
+// split は s をワード差分マーカーで区切り、等価・削除・追加の断片に分解します。
+// 閉じられていないマーカーは以降を通常の文字として扱い、その場合 open に true を返します
+func (m *MarkerSyntax) split(s string) (segments []diffmatchpatch.Diff, open bool) {
+	m = m.orDefault()
+	start := 0 // 未出力の等価部分の開始位置
+	for i := 0; i < len(s); {
+		next := strings.IndexAny(s[i:], m.openers)
+		if next < 0 {
+			break
+		}
+		i += next
+		pair, op, ok := m.opener(s[i:])
+		if !ok {
+			i++
+			continue
+		}
+		textStart := i + len(pair.Open)
+		end := strings.Index(s[textStart:], pair.Close)
+		if end < 0 {
+			open = true
+			break
+		}
+		if start < i {
+			segments = append(segments, diffmatchpatch.Diff{Type: diffmatchpatch.DiffEqual, Text: s[start:i]})
+		}
+		segments = append(segments, diffmatchpatch.Diff{Type: op, Text: s[textStart : textStart+end]})
+		i = textStart + end + len(pair.Close)
+		start = i
+	}
+	if start < len(s) {
+		segments = append(segments, diffmatchpatch.Diff{Type: diffmatchpatch.DiffEqual, Text: s[start:]})
+	}
+	return segments, open
+}
+
+// pendingCloser は s を走査し、走査後も閉じられていないマーカーの終了記号を返します。
+// pending には前の行から持ち越した終了記号を渡します (なければ空文字)
+func (m *MarkerSyntax) pendingCloser(s, pending string) string {
+	m = m.orDefault()
+	for i := 0; i < len(s); {
+		if pending != "" {
+			if strings.HasPrefix(s[i:], pending) {
+				i += len(pending)
+				pending = ""
+			} else {
+				i++
+			}
+			continue
+		}
+		if pair, _, ok := m.opener(s[i:]); ok {
+			pending = pair.Close
+			i += len(pair.Open)
+			continue
+		}
+		i++
+	}
+	return pending
+}
+
+// matchRow は行全体が追加または削除のマーカーで囲まれているかを調べ、囲まれている場合はその内側を返します
+func (m *MarkerSyntax) matchRow(line string) (content string, isRowAdd, isRowDel bool) {
+	m = m.orDefault()
+	if matches := m.rowAdd.FindStringSubmatch(line); matches != nil {
+		return firstNonEmpty(matches[1:]), true, false
+	}
+	if matches := m.rowDel.FindStringSubmatch(line); matches != nil {
+		return firstNonEmpty(matches[1:]), false, true
+	}
+	return line, false, false
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// mark は text を op に対応するマーカーで囲みます
+func (m *MarkerSyntax) mark(text string, op diffmatchpatch.Operation) string {
+	m = m.orDefault()
+	switch op {
+	case diffmatchpatch.DiffDelete:
+		return m.Delete[0].Open + text + m.Delete[0].Close
+	case diffmatchpatch.DiffInsert:
+		return m.Insert[0].Open + text + m.Insert[0].Close
+	}
+	return text
+}
+
+// markRow は行全体の追加/削除として各セルをマーカーで囲みます。
+// 空セルはマーカーを付けずにそのまま残します
+func (m *MarkerSyntax) markRow(record []string, op diffmatchpatch.Operation) []string {
+	marked := make([]string, len(record))
+	for i, cell := range record {
+		if cell != "" {
+			marked[i] = m.mark(cell, op)
+		}
+	}
+	return marked
+}
+
+// markChangedRow は旧行と新行をセル単位で比較し、異なるセルにマーカーを付与します
+func (m *MarkerSyntax) markChangedRow(oldRecord, newRecord []string) []string {
+	n := max(len(oldRecord), len(newRecord))
+	marked := make([]string, n)
+	for i := range n {
+		var oldCell, newCell string
+		if i < len(oldRecord) {
+			oldCell = oldRecord[i]
+		}
+		if i < len(newRecord) {
+			newCell = newRecord[i]
+		}
+		marked[i] = FormatDiffsToText(cellSegmentsOf(oldCell, newCell), m)
+	}
+	return marked
+}
+
+// diffTexts は差分の断片から変更前 (等価+削除) と変更後 (等価+追加) のテキストを復元します
+func diffTexts(diffs []diffmatchpatch.Diff) (oldText, newText string) {
+	var oldB, newB strings.Builder
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			oldB.WriteString(d.Text)
+			newB.WriteString(d.Text)
+		case diffmatchpatch.DiffDelete:
+			oldB.WriteString(d.Text)
+		case diffmatchpatch.DiffInsert:
+			newB.WriteString(d.Text)
+		}
+	}
+	return oldB.String(), newB.String()
+}
+
+// hasChange は差分の断片に削除または追加が含まれるかを返します
+func hasChange(diffs []diffmatchpatch.Diff) bool {
+	for _, d := range diffs {
+		if d.Type != diffmatchpatch.DiffEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// markersCrossCells は削除・追加の断片にカンマが含まれ、マーカーが複数のセルにまたがっているかを返します
+func markersCrossCells(segments []diffmatchpatch.Diff) bool {
+	for _, d := range segments {
+		if d.Type != diffmatchpatch.DiffEqual && strings.Contains(d.Text, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// trimBlankEdges は先頭と末尾にある空白のみの等価部分を取り除きます
+func trimBlankEdges(segments []diffmatchpatch.Diff) []diffmatchpatch.Diff {
+	isBlank := func(d diffmatchpatch.Diff) bool {
+		return d.Type == diffmatchpatch.DiffEqual && strings.TrimSpace(d.Text) == ""
+	}
+	for len(segments) > 0 && isBlank(segments[0]) {
+		segments = segments[1:]
+	}
+	for len(segments) > 0 && isBlank(segments[len(segments)-1]) {
+		segments = segments[:len(segments)-1]
+	}
+	return segments
+}
+
+// splitSegmentCells は1行分の差分の断片をカンマでセルごとに分割し、各セルの変更後の値と断片を返します。
+// 削除・追加の断片がカンマを含む場合は、変更前と変更後をそれぞれ分割して同じ位置のセル同士を対応付けます
+func splitSegmentCells(segments []diffmatchpatch.Diff) (cells []string, cellSegments [][]diffmatchpatch.Diff) {
+	if markersCrossCells(segments) {
+		oldText, newText := diffTexts(segments)
+		oldFields, newFields := splitSimpleFields(oldText), splitSimpleFields(newText)
+		for i := range max(len(oldFields), len(newFields)) {
+			var oldCell, newCell string
+			if i < len(oldFields) {
+				oldCell = oldFields[i]
+			}
+			if i < len(newFields) {
+				newCell = newFields[i]
+			}
+			cells = append(cells, newCell)
+			cellSegments = append(cellSegments, cellSegmentsOf(oldCell, newCell))
+		}
+		return cells, cellSegments
+	}
+
+	var current []diffmatchpatch.Diff
+	for _, d := range segments {
+		if d.Type != diffmatchpatch.DiffEqual {
+			current = append(current, d)
+			continue
+		}
+		for i, part := range strings.Split(d.Text, ",") {
+			if i > 0 {
+				cellSegments = append(cellSegments, current)
+				current = nil
+			}
+			if part != "" {
+				current = append(current, diffmatchpatch.Diff{Type: diffmatchpatch.DiffEqual, Text: part})
+			}
+		}
+	}
+	cellSegments = append(cellSegments, current)
+
+	cells = make([]string, len(cellSegments))
+	for i, segs := range cellSegments {
+		_, cells[i] = diffTexts(segs)
+		if !hasChange(segs) {
+			// 変更のないセルは SimpleCSVReader と同様に囲むクォートを除去する
+			cells[i] = splitSimpleFields(cells[i])[0]
+		}
+	}
+	return cells, cellSegments
+}
+
+// cellSegmentsOf は1セル分の旧値と新値から差分の断片を生成します
+func cellSegmentsOf(oldCell, newCell string) []diffmatchpatch.Diff {
+	switch {
+	case oldCell == newCell:
+		return []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffEqual, Text: newCell}}
+	case oldCell == "":
+		return []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffInsert, Text: newCell}}
+	case newCell == "":
+		return []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffDelete, Text: oldCell}}
+	default:
+		return []diffmatchpatch.Diff{
+			{Type: diffmatchpatch.DiffDelete, Text: oldCell},
+			{Type: diffmatchpatch.DiffInsert, Text: newCell},
+		}
+	}
+}
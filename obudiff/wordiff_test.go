@@ -0,0 +1,97 @@
+package obudiff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestParseMarkerSyntax(t *testing.T) {
+	markers, err := ParseMarkerSyntax("<<del>> <</del>> <<ins>> <</ins>>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if markers.Delete[0] != (MarkerPair{"<<del>>", "<</del>>"}) || markers.Insert[0] != (MarkerPair{"<<ins>>", "<</ins>>"}) {
+		t.Errorf("unexpected syntax: %+v", markers)
+	}
+
+	for _, spec := range []string{"", "[- -] {+", "<< >> << >>"} {
+		if _, err := ParseMarkerSyntax(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestCustomMarkers(t *testing.T) {
+	markers, err := ParseMarkerSyntax("<<del>> <</del>> <<ins>> <</ins>>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Split", func(t *testing.T) {
+		// 既定の記法の [-x-] はデータとして扱われる
+		segments, open := markers.split("a<<del>>b<</del>><<ins>>c<</ins>>[-x-]")
+		if open {
+			t.Error("open should be false")
+		}
+		expected := []diffmatchpatch.Diff{
+			{Type: diffmatchpatch.DiffEqual, Text: "a"},
+			{Type: diffmatchpatch.DiffDelete, Text: "b"},
+			{Type: diffmatchpatch.DiffInsert, Text: "c"},
+			{Type: diffmatchpatch.DiffEqual, Text: "[-x-]"},
+		}
+		if len(segments) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, segments)
+		}
+		for i := range expected {
+			if segments[i] != expected[i] {
+				t.Errorf("segment[%d]: expected %v, got %v", i, expected[i], segments[i])
+			}
+		}
+	})
+
+	t.Run("SimpleCSVReader", func(t *testing.T) {
+		reader := NewSimpleCSVReader(strings.NewReader("<<ins>>1,A<</ins>>\n2,<<del>>B,C<</del>><<ins>>D,E<</ins>>\n3,<<del>>x\ny<</del>>"))
+		reader.Markers = markers
+		reader.MultiLine = true
+		expected := [][]string{
+			{"<<ins>>1<</ins>>", "<<ins>>A<</ins>>"},
+			{"2", "<<del>>B<</del>><<ins>>D<</ins>>", "<<del>>C<</del>><<ins>>E<</ins>>"},
+			{"3", "<<del>>x\ny<</del>>"},
+		}
+		for i, want := range expected {
+			record, err := reader.Read()
+			if err != nil {
+				t.Fatalf("record[%d]: unexpected error: %v", i, err)
+			}
+			if strings.Join(record, "|") != strings.Join(want, "|") {
+				t.Errorf("record[%d]: expected %q, got %q", i, want, record)
+			}
+		}
+		if _, err := reader.Read(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Processing", func(t *testing.T) {
+		cfg := Config{Markers: markers, OutputMarkers: DefaultMarkers}
+		reader := newRecordReader(strings.NewReader("1,<<del>>OK<</del>><<ins>>NG<</ins>>,[-raw-]"), cfg, true)
+		var outBuf bytes.Buffer
+		writer := bufio.NewWriter(&outBuf)
+		dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+		defer dmpPool.Put(dmp)
+
+		if err := executeProcessing(context.Background(), cfg, reader, writer, dmp); err != nil {
+			t.Fatal(err)
+		}
+		writer.Flush()
+		if expected := "1,[-OK-]{+NG+},[-raw-]\n"; outBuf.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, outBuf.String())
+		}
+	})
+}
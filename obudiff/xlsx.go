@@ -0,0 +1,436 @@
+package obudiff
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// XLSX のセルスタイル (styles.xml の cellXfs の位置)
+const (
+	xlsxStyleNone   = 0
+	xlsxStyleHeader = 1
+	xlsxStyleRowAdd = 2 // HTMLの diff-row-add と同じ背景色
+	xlsxStyleRowDel = 3 // HTMLの diff-row-del と同じ背景色
+)
+
+// Excel のシートの上限
+var (
+	xlsxMaxRows      = 1048576 // 1シートの最大行数
+	xlsxMaxColumns   = 16384   // 1シートの最大列数 (XFD)
+	xlsxMaxCellChars = 32767   // 1セルの最大文字数 (UTF-16 の単位)
+)
+
+// xlsxStaticParts はシートの数によらない固定のパーツです。
+// [Content_Types].xml, workbook.xml とそのリレーションはシートの数に応じて Close で書き込みます
+var xlsxStaticParts = []struct{ name, content string }{
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="4">
+<fill><patternFill patternType="none"/></fill>
+<fill><patternFill patternType="gray125"/></fill>
+<fill><patternFill patternType="solid"><fgColor rgb="FFE6FFED"/><bgColor indexed="64"/></patternFill></fill>
+<fill><patternFill patternType="solid"><fgColor rgb="FFFFEEF0"/><bgColor indexed="64"/></patternFill></fill>
+</fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="0" fontId="0" fillId="2" borderId="0" xfId="0" applyFill="1"/>
+<xf numFmtId="0" fontId="0" fillId="3" borderId="0" xfId="0" applyFill="1"/>
+</cellXfs>
+</styleSheet>`},
+}
+
+// 差分の断片のリッチテキスト書式 (HTMLの .diff-del / .diff-add と同じ色)
+const (
+	xlsxDeleteRunProps = `<rPr><strike/><color rgb="FFD32F2F"/></rPr>`
+	xlsxInsertRunProps = `<rPr><b/><color rgb="FF388E3C"/></rPr>`
+)
+
+// xlsxCell は1セル分の内容です。diffs が nil の場合は text をそのまま書き込みます。
+// numeric が true の場合は text を数値として書き込みます
+type xlsxCell struct {
+	text    string
+	diffs   []diffmatchpatch.Diff
+	numeric bool
+}
+
+// xlsxWriter は .xlsx を書き出します。行は writeRow で順に追記し、
+// 1シートの最大行数に達した場合は新しいシートを作成して続きを書き込みます (見出し行はシートごとに繰り返します)。
+// 最後に必ず Close を呼び出してください
+type xlsxWriter struct {
+	zw     *zip.Writer
+	logger *slog.Logger
+	sheet  io.Writer
+	sheets int // 作成したシートの数
+	row    int // 現在のシートに書き込んだ行数
+	header []xlsxCell
+	buf    bytes.Buffer
+}
+
+// newXLSXWriter は w に書き出す xlsxWriter を作成します。logger は上限に達した場合の通知に使用します (nil の場合は出力しない)
+func newXLSXWriter(w io.Writer, logger *slog.Logger) (*xlsxWriter, error) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		if err := writeXLSXPart(zw, part.name, part.content); err != nil {
+			return nil, err
+		}
+	}
+	x := &xlsxWriter{zw: zw, logger: logger}
+	if err := x.startSheet(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// writeXLSXPart は zip に content を内容とするパーツを追加します
+func writeXLSXPart(zw *zip.Writer, name, content string) error {
+	f, err := createXLSXPart(zw, name)
+	if err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗 (%s): %w", name, err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗 (%s): %w", name, err)
+	}
+	return nil
+}
+
+// xlsxSheetName は1始まりのシート番号に対応するシート名を返します
+func xlsxSheetName(n int) string {
+	if n == 1 {
+		return "Diff"
+	}
+	return fmt.Sprintf("Diff (%d)", n)
+}
+
+// startSheet は新しいシートのパーツを作成し、見出し行があれば書き込みます
+func (x *xlsxWriter) startSheet() error {
+	x.sheets++
+	x.row = 0
+	name := fmt.Sprintf("xl/worksheets/sheet%d.xml", x.sheets)
+	sheet, err := createXLSXPart(x.zw, name)
+	if err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗 (%s): %w", name, err)
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗 (%s): %w", name, err)
+	}
+	x.sheet = sheet
+	if x.header != nil {
+		return x.appendRow(x.header, xlsxStyleHeader)
+	}
+	return nil
+}
+
+// endSheet は現在のシートを閉じます
+func (x *xlsxWriter) endSheet() error {
+	if _, err := io.WriteString(x.sheet, "</sheetData></worksheet>"); err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗 (sheet%d.xml): %w", x.sheets, err)
+	}
+	return nil
+}
+
+// createXLSXPart は zip にパーツを追加します。
+// 更新日時を指定しないと不正な日付 (月が0) になるため、zip の日付の下限を設定します
+func createXLSXPart(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+}
+
+// writeHeader は見出し行を書き込み、以降に作成するシートの先頭にも同じ見出し行を書き込むようにします
+func (x *xlsxWriter) writeHeader(cells []xlsxCell) error {
+	if err := x.writeRow(cells, xlsxStyleHeader); err != nil {
+		return err
+	}
+	x.header = cells
+	return nil
+}
+
+// writeRow は1行を書き込みます。style は行内のすべてのセルに適用するスタイルです。
+// 現在のシートが最大行数に達している場合は新しいシートに書き込みます。
+// 列数が上限を超える場合はエラーを返し、文字数が上限を超えるセルは切り詰めて警告を出力します
+func (x *xlsxWriter) writeRow(cells []xlsxCell, style int) error {
+	if len(cells) > xlsxMaxColumns {
+		return fmt.Errorf("列数 (%d) がExcelの上限 (%d) を超えているためXLSXに出力できません", len(cells), xlsxMaxColumns)
+	}
+	if x.row >= xlsxMaxRows {
+		if err := x.endSheet(); err != nil {
+			return err
+		}
+		if err := x.startSheet(); err != nil {
+			return err
+		}
+		x.logger.Info("シートの最大行数に達したため、新しいシートに出力します", "sheet", xlsxSheetName(x.sheets))
+	}
+	return x.appendRow(cells, style)
+}
+
+// appendRow は現在のシートに1行を追記します
+func (x *xlsxWriter) appendRow(cells []xlsxCell, style int) error {
+	x.row++
+	x.buf.Reset()
+	fmt.Fprintf(&x.buf, `<row r="%d">`, x.row)
+	for i, cell := range cells {
+		ref := xlsxColumnName(i) + strconv.Itoa(x.row)
+		styleAttr := ""
+		if style != xlsxStyleNone {
+			styleAttr = fmt.Sprintf(` s="%d"`, style)
+		}
+		if cell.diffs == nil && cell.text == "" {
+			if style != xlsxStyleNone {
+				fmt.Fprintf(&x.buf, `<c r="%s"%s/>`, ref, styleAttr)
+			}
+			continue
+		}
+
+		if cell.numeric {
+			fmt.Fprintf(&x.buf, `<c r="%s"%s><v>%s</v></c>`, ref, styleAttr, cell.text)
+			continue
+		}
+		if truncated, ok := truncateXLSXCell(cell); ok {
+			cell = truncated
+			x.logger.Warn("セルの文字数がExcelの上限を超えたため切り詰めました", "sheet", xlsxSheetName(x.sheets), "cell", ref, "limit", xlsxMaxCellChars)
+		}
+		fmt.Fprintf(&x.buf, `<c r="%s"%s t="inlineStr"><is>`, ref, styleAttr)
+		if cell.diffs == nil {
+			x.writeText(cell.text)
+		}
+		for _, d := range cell.diffs {
+			x.buf.WriteString("<r>")
+			switch d.Type {
+			case diffmatchpatch.DiffDelete:
+				x.buf.WriteString(xlsxDeleteRunProps)
+			case diffmatchpatch.DiffInsert:
+				x.buf.WriteString(xlsxInsertRunProps)
+			}
+			x.writeText(d.Text)
+			x.buf.WriteString("</r>")
+		}
+		x.buf.WriteString("</is></c>")
+	}
+	x.buf.WriteString("</row>\n")
+
+	if _, err := x.sheet.Write(x.buf.Bytes()); err != nil {
+		return fmt.Errorf("XLSX行の書き込みに失敗: %w", err)
+	}
+	return nil
+}
+
+// truncateXLSXCell はセルの文字数 (差分の断片の合計) が xlsxMaxCellChars を超える場合に、
+// 上限までに切り詰めたセルと true を返します
+func truncateXLSXCell(cell xlsxCell) (xlsxCell, bool) {
+	if cell.diffs == nil {
+		text, ok := truncateUTF16(cell.text, xlsxMaxCellChars)
+		return xlsxCell{text: text}, ok
+	}
+	remaining := xlsxMaxCellChars
+	for i, d := range cell.diffs {
+		text, ok := truncateUTF16(d.Text, remaining)
+		if !ok {
+			remaining -= utf16Len(d.Text)
+			continue
+		}
+		diffs := append(slices.Clip(cell.diffs[:i]), diffmatchpatch.Diff{Type: d.Type, Text: text})
+		return xlsxCell{diffs: diffs}, true
+	}
+	return cell, false
+}
+
+// truncateUTF16 は s が UTF-16 で limit 単位を超える場合に、文字の途中で切らずに limit 以内へ切り詰めた文字列と true を返します
+func truncateUTF16(s string, limit int) (string, bool) {
+	n := 0
+	for i, r := range s {
+		n += utf16.RuneLen(r)
+		if n > limit {
+			return s[:i], true
+		}
+	}
+	return s, false
+}
+
+// utf16Len は s を UTF-16 で表したときの長さを返します
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func (x *xlsxWriter) writeText(s string) {
+	x.buf.WriteString(`<t xml:space="preserve">`)
+	xml.EscapeText(&x.buf, []byte(s))
+	x.buf.WriteString("</t>")
+}
+
+// Close はシートを閉じ、シートの一覧を含むパーツと zip の中央ディレクトリを書き込みます
+func (x *xlsxWriter) Close() error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+
+	var types, sheets, rels strings.Builder
+	types.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+`)
+	for n := 1; n <= x.sheets; n++ {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+`, n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxSheetName(n), n, n+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>
+`, n+1, n)
+	}
+	types.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`)
+	rels.WriteString("</Relationships>")
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", types.String()},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>` + sheets.String() + `</sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+	}
+	for _, part := range parts {
+		if err := writeXLSXPart(x.zw, part.name, part.content); err != nil {
+			return err
+		}
+	}
+
+	if err := x.zw.Close(); err != nil {
+		return fmt.Errorf("XLSXの書き込みに失敗: %w", err)
+	}
+	return nil
+}
+
+// xlsxColumnName は0始まりの列番号を A, B, ..., Z, AA, ... の列名に変換します
+func xlsxColumnName(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append(name, byte('A'+(i-1)%26))
+	}
+	for l, r := 0, len(name)-1; l < r; l, r = l+1, r-1 {
+		name[l], name[r] = name[r], name[l]
+	}
+	return string(name)
+}
+
+// xlsxTextCells は文字列の並びを書式なしのセルに変換します
+func xlsxTextCells(values []string) []xlsxCell {
+	cells := make([]xlsxCell, len(values))
+	for i, v := range values {
+		cells[i].text = v
+	}
+	return cells
+}
+
+// xlsxTableRenderer は全データをXLSXのシートに出力します。
+// 差分のあるセルはリッチテキストで削除と追加を色分けし、行全体の追加・削除は行の背景色で示します
+type xlsxTableRenderer struct {
+	w          io.Writer
+	trimSpaces bool
+	logger     *slog.Logger
+	x          *xlsxWriter
+}
+
+func (r *xlsxTableRenderer) Begin(headers []string) error {
+	var err error
+	if r.x, err = newXLSXWriter(r.w, r.logger); err != nil {
+		return err
+	}
+	if headers != nil {
+		return r.x.writeHeader(xlsxTextCells(headers))
+	}
+	return nil
+}
+
+func (r *xlsxTableRenderer) Row(row *DiffRow) error {
+	cells := make([]xlsxCell, len(row.Cells))
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if r.trimSpaces {
+			cell.TrimRight()
+		}
+		if cell.IsDiff {
+			cells[i].diffs = cell.Diffs
+		} else {
+			cells[i].text = cell.Value
+		}
+	}
+
+	style := xlsxStyleNone
+	switch row.Change {
+	case RowAdded:
+		style = xlsxStyleRowAdd
+	case RowDeleted:
+		style = xlsxStyleRowDel
+	}
+	if err := r.x.writeRow(cells, style); err != nil {
+		return fmt.Errorf("line %d: %w", row.Line, err)
+	}
+	return nil
+}
+
+func (r *xlsxTableRenderer) End() error { return r.x.Close() }
+
+// xlsxListRenderer は差分のあるセルのみを Line, Column, DiffValue の3列のシートに出力します
+type xlsxListRenderer struct {
+	w      io.Writer
+	logger *slog.Logger
+	x      *xlsxWriter
+}
+
+func (r *xlsxListRenderer) Begin(headers []string) error {
+	var err error
+	if r.x, err = newXLSXWriter(r.w, r.logger); err != nil {
+		return err
+	}
+	return r.x.writeHeader(xlsxTextCells([]string{"Line", "Column", "DiffValue"}))
+}
+
+func (r *xlsxListRenderer) Row(row *DiffRow) error {
+	for i := range row.Cells {
+		cell := &row.Cells[i]
+		if !cell.IsDiff {
+			continue
+		}
+		cells := []xlsxCell{{text: strconv.Itoa(row.Line), numeric: true}, {text: columnLabel(cell)}, {diffs: cell.Diffs}}
+		if err := r.x.writeRow(cells, xlsxStyleNone); err != nil {
+			return fmt.Errorf("line %d: %w", row.Line, err)
+		}
+	}
+	return nil
+}
+
+func (r *xlsxListRenderer) End() error { return r.x.Close() }
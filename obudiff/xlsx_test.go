@@ -0,0 +1,183 @@
+package obudiff
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestXLSXColumnName(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumnName(i); got != expected {
+			t.Errorf("%d: expected %s, got %s", i, expected, got)
+		}
+	}
+}
+
+// readXLSXParts は .xlsx の各パーツを読み込み、XMLとして整形式であることを確認します
+func readXLSXParts(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: invalid XML: %v", f.Name, err)
+			}
+		}
+		parts[f.Name] = string(content)
+	}
+	return parts
+}
+
+func TestXLSXOutput(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+	input := "1,Apple,[-OK-]{+NG+}\n{+2,Banana,<new>+}\n[-3-],[-Grape-],"
+
+	run := func(t *testing.T, cfg Config) map[string]string {
+		t.Helper()
+		var outBuf bytes.Buffer
+		writer := bufio.NewWriter(&outBuf)
+		if err := executeProcessing(context.Background(), cfg, newReader(input, false), writer, dmp); err != nil {
+			t.Fatal(err)
+		}
+		writer.Flush()
+		return readXLSXParts(t, outBuf.Bytes())
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		parts := run(t, Config{Format: "xlsx", Headers: []string{"ID", "Name", "Status"}})
+		for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+			if _, ok := parts[name]; !ok {
+				t.Errorf("missing part %s", name)
+			}
+		}
+		sheet := parts["xl/worksheets/sheet1.xml"]
+		expected := []string{
+			`<row r="1"><c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">ID</t></is></c>`,
+			`<c r="C2" t="inlineStr"><is><r><rPr><strike/><color rgb="FFD32F2F"/></rPr><t xml:space="preserve">OK</t></r><r><rPr><b/><color rgb="FF388E3C"/></rPr><t xml:space="preserve">NG</t></r></is></c>`,
+			`<row r="3"><c r="A3" s="2" t="inlineStr">`,
+			`<t xml:space="preserve">&lt;new&gt;</t>`,
+			`<row r="4"><c r="A4" s="3" t="inlineStr">`,
+			`<c r="C4" s="3"/></row>`,
+		}
+		for _, want := range expected {
+			if !strings.Contains(sheet, want) {
+				t.Errorf("sheet should contain %s\n%s", want, sheet)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		sheet := run(t, Config{Format: "xlsx", LightMode: true})["xl/worksheets/sheet1.xml"]
+		want := `<row r="2"><c r="A2"><v>1</v></c><c r="B2" t="inlineStr"><is><t xml:space="preserve">3</t></is></c>`
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet should contain %s\n%s", want, sheet)
+		}
+		if n := strings.Count(sheet, "<row "); n != 1+1+3+2 {
+			t.Errorf("expected 7 rows (header + 6 diff cells), got %d", n)
+		}
+	})
+}
+
+func TestXLSXLimits(t *testing.T) {
+	dmp := dmpPool.Get().(*diffmatchpatch.DiffMatchPatch)
+	defer dmpPool.Put(dmp)
+
+	run := func(t *testing.T, cfg Config, input string) (map[string]string, error) {
+		t.Helper()
+		var outBuf bytes.Buffer
+		if err := executeProcessing(context.Background(), cfg, newReader(input, false), &outBuf, dmp); err != nil {
+			return nil, err
+		}
+		return readXLSXParts(t, outBuf.Bytes()), nil
+	}
+
+	t.Run("Rows", func(t *testing.T) {
+		defer func(n int) { xlsxMaxRows = n }(xlsxMaxRows)
+		xlsxMaxRows = 3
+
+		// 最大行数を超えた行は見出し行を繰り返した新しいシートに出力する
+		parts, err := run(t, Config{Format: "xlsx", Headers: []string{"ID", "Value"}}, "1,a\n2,b\n3,c\n4,d\n5,[-e-]{+f+}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, rows := range map[int]int{1: 3, 2: 3, 3: 2} {
+			sheet := parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", n)]
+			if got := strings.Count(sheet, "<row "); got != rows {
+				t.Errorf("sheet%d: expected %d rows, got %d", n, rows, got)
+			}
+			if !strings.Contains(sheet, `<row r="1"><c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">ID</t>`) {
+				t.Errorf("sheet%d should start with the header row\n%s", n, sheet)
+			}
+		}
+		if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Diff (3)" sheetId="3" r:id="rId4"/>`) {
+			t.Errorf("workbook should list 3 sheets\n%s", parts["xl/workbook.xml"])
+		}
+		if !strings.Contains(parts["[Content_Types].xml"], `/xl/worksheets/sheet3.xml`) {
+			t.Errorf("content types should include sheet3\n%s", parts["[Content_Types].xml"])
+		}
+		if !strings.Contains(parts["xl/_rels/workbook.xml.rels"], `Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet3.xml"`) {
+			t.Errorf("workbook relationships should include sheet3\n%s", parts["xl/_rels/workbook.xml.rels"])
+		}
+	})
+
+	t.Run("Columns", func(t *testing.T) {
+		defer func(n int) { xlsxMaxColumns = n }(xlsxMaxColumns)
+		xlsxMaxColumns = 2
+
+		if _, err := run(t, Config{Format: "xlsx"}, "1,a,[-b-]{+c+}"); err == nil || !strings.Contains(err.Error(), "列数") {
+			t.Errorf("expected column limit error, got %v", err)
+		}
+	})
+
+	t.Run("CellChars", func(t *testing.T) {
+		defer func(n int) { xlsxMaxCellChars = n }(xlsxMaxCellChars)
+		xlsxMaxCellChars = 5
+
+		// 上限を超えるセルは差分の断片の途中で切り詰め、警告を出力する
+		var logBuf bytes.Buffer
+		cfg := Config{Format: "xlsx", Logger: slog.New(slog.NewTextHandler(&logBuf, nil))}
+		parts, err := run(t, cfg, "1,[-abc-]{+defgh+},123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet := parts["xl/worksheets/sheet1.xml"]
+		for _, want := range []string{
+			`<t xml:space="preserve">abc</t></r><r><rPr><b/><color rgb="FF388E3C"/></rPr><t xml:space="preserve">de</t></r></is></c>`,
+			`<c r="C1" t="inlineStr"><is><t xml:space="preserve">12345</t></is></c>`,
+		} {
+			if !strings.Contains(sheet, want) {
+				t.Errorf("sheet should contain %s\n%s", want, sheet)
+			}
+		}
+		if n := strings.Count(logBuf.String(), "level=WARN"); n != 2 {
+			t.Errorf("expected 2 warnings, got %d:\n%s", n, logBuf.String())
+		}
+	})
+}
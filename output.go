@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// stdoutPath は -o に指定すると標準出力へ書き込む出力パスです
+const stdoutPath = "-"
+
+// writeOutput は path が stdoutPath の場合は stdout へ、それ以外は writeFileAtomic で path へ write の結果を書き込みます
+func writeOutput(path string, stdout io.Writer, write func(w io.Writer) error) error {
+	if path != stdoutPath {
+		return writeFileAtomic(path, write)
+	}
+	return writeBuffered(stdout, write)
+}
+
+// writeFileAtomic は path と同じディレクトリに作成した一時ファイルへ write で書き込み、
+// 成功した場合のみ path へ名前を変更します。
+// write や書き込みが失敗した場合は一時ファイルを削除し、既存の path には一切触れません。
+// path が /dev/null や名前付きパイプのように通常のファイルでない場合は、名前を変更できないため直接書き込みます。
+// path がシンボリックリンクの場合はリンク自体を残し、リンク先のファイルを置き換えます
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// リンク先が存在しない場合は os.Create と同じくリンクをたどって作成する
+			return writeDirect(path, write)
+		}
+		path = resolved
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// 新しく作成する
+	case err != nil:
+		return fmt.Errorf("出力ファイルの情報を取得できません: %w", err)
+	case !info.Mode().IsRegular():
+		return writeDirect(path, write)
+	}
+
+	tmp, err := createTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("一時ファイルを作成できません: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := writeBuffered(tmp, write); err != nil {
+		return err
+	}
+	// 既存のファイルを置き換える場合は、その権限を引き継ぐ
+	if info != nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			return fmt.Errorf("一時ファイルの権限を設定できません: %w", err)
+		}
+	}
+	// 名前を変更した後にクラッシュしても空のファイルが残らないよう、内容をディスクへ書き出しておく
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("一時ファイルをディスクへ書き出せません: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("一時ファイルを閉じられません: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("出力ファイルを置き換えられません: %w", err)
+	}
+	return nil
+}
+
+// createTemp は dir に "."+base+".<乱数>.tmp" という名前の一時ファイルを作成します。
+// os.CreateTemp は権限を 0600 に固定するため、os.Create と同じく 0666 から umask を除いた権限で作成します
+func createTemp(dir, base string) (*os.File, error) {
+	for range 10000 {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(rand.Uint64(), 36)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("一時ファイル名を決定できません: %s", dir)
+}
+
+// writeDirect は一時ファイルを使わずに path へ直接 write の結果を書き込みます
+func writeDirect(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("出力ファイルを作成できません: %w", err)
+	}
+	if err := writeBuffered(f, write); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("出力ファイルを閉じられません: %w", err)
+	}
+	return nil
+}
+
+// writeBuffered は w をバッファリングして write の結果を書き込み、最後にバッファを書き出します
+func writeBuffered(w io.Writer, write func(w io.Writer) error) error {
+	writer := bufio.NewWriter(w)
+	if err := write(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("出力の書き込みに失敗: %w", err)
+	}
+	return nil
+}
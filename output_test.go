@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteOutputStdout(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	var stdout bytes.Buffer
+	err := writeOutput(stdoutPath, &stdout, func(w io.Writer) error {
+		_, err := io.WriteString(w, "1,[-a-]{+b+}\n")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1,[-a-]{+b+}\n"; stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+	// "-" という名前のファイルは作成しない
+	assertOnlyFile(t, dir)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Run("Replace", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.csv")
+		if err := os.WriteFile(path, []byte("old\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		err := writeFileAtomic(path, func(w io.Writer) error {
+			_, err := io.WriteString(w, "new\n")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "new\n" {
+			t.Errorf("expected %q, got %q", "new\n", got)
+		}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0o640 {
+				t.Errorf("existing permission should be kept, got %v", perm)
+			}
+		}
+		assertOnlyFile(t, dir, "out.csv")
+	})
+
+	t.Run("FailureKeepsPrevious", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.csv")
+		if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		errWrite := errors.New("write failed")
+		err := writeFileAtomic(path, func(w io.Writer) error {
+			io.WriteString(w, "partial")
+			return errWrite
+		})
+		if !errors.Is(err, errWrite) {
+			t.Fatalf("expected write error, got %v", err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "old\n" {
+			t.Errorf("previous output should be kept, got %q", got)
+		}
+		assertOnlyFile(t, dir, "out.csv")
+	})
+
+	t.Run("FailureCreatesNothing", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.csv")
+		err := writeFileAtomic(path, func(w io.Writer) error { return errors.New("write failed") })
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("output should not be created, stat error: %v", err)
+		}
+		assertOnlyFile(t, dir)
+	})
+
+	t.Run("NewFileMode", func(t *testing.T) {
+		// os.Create と同じく umask を反映した権限で作成する
+		dir := t.TempDir()
+		ref, err := os.Create(filepath.Join(dir, "ref"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref.Close()
+		refInfo, err := os.Stat(ref.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "out.csv")
+		if err := writeFileAtomic(path, func(w io.Writer) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != refInfo.Mode().Perm() {
+			t.Errorf("expected permission %v, got %v", refInfo.Mode().Perm(), info.Mode().Perm())
+		}
+	})
+
+	t.Run("Symlink", func(t *testing.T) {
+		// シンボリックリンクはリンク自体を残し、リンク先の内容を置き換える
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks require privileges")
+		}
+		dir := t.TempDir()
+		target := filepath.Join(dir, "target.csv")
+		if err := os.WriteFile(target, []byte("old\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "out.csv")
+		if err := os.Symlink("target.csv", path); err != nil {
+			t.Fatal(err)
+		}
+		err := writeFileAtomic(path, func(w io.Writer) error {
+			_, err := io.WriteString(w, "new\n")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("output path should remain a symlink: %v, %v", info, err)
+		}
+		got, err := os.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "new\n" {
+			t.Errorf("expected %q, got %q", "new\n", got)
+		}
+		if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o640 {
+			t.Errorf("existing permission should be kept: %v, %v", info, err)
+		}
+		assertOnlyFile(t, dir, "out.csv", "target.csv")
+	})
+
+	t.Run("DanglingSymlink", func(t *testing.T) {
+		// リンク先が存在しない場合はリンクをたどって作成する
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks require privileges")
+		}
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.csv")
+		if err := os.Symlink("target.csv", path); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(path, func(w io.Writer) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("output path should remain a symlink: %v, %v", info, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "target.csv")); err != nil {
+			t.Errorf("link target should be created: %v", err)
+		}
+	})
+
+	t.Run("DevNull", func(t *testing.T) {
+		// 通常のファイルでない出力先には直接書き込む
+		if runtime.GOOS == "windows" {
+			t.Skip("/dev/null is not available")
+		}
+		err := writeFileAtomic(os.DevNull, func(w io.Writer) error {
+			_, err := io.WriteString(w, "discarded\n")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		if err := writeFileAtomic(t.TempDir(), func(w io.Writer) error { return nil }); err == nil {
+			t.Error("expected error for directory output")
+		}
+	})
+}
+
+// assertOnlyFile は dir に names 以外のファイル (一時ファイルなど) が残っていないことを確認します
+func assertOnlyFile(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if len(got) != len(names) {
+		t.Fatalf("expected files %v, got %v", names, got)
+	}
+	for i := range names {
+		if got[i] != names[i] {
+			t.Fatalf("expected files %v, got %v", names, got)
+		}
+	}
+}